@@ -0,0 +1,119 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import "crypto/tls"
+
+// UpdateAuth replaces the authentication settings of the connection with
+// the ones set by the given options, such as UserInfo, Token, TokenHandler,
+// UserJWT, Nkey or UserCredentials. Authentication settings not set by the
+// options are cleared, and other settings are ignored.
+// Credentials embedded in the server URLs still take precedence.
+//
+// The new settings are used from the next reconnect, unless `force` is
+// true, in which case the connection reconnects, as with Conn.Reconnect,
+// to apply them immediately. Note that UserCredentials reads the files on
+// every connect, so rotating the files does not require an update.
+func (nc *Conn) UpdateAuth(force bool, opts ...Option) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		return ErrConnectionClosed
+	}
+	o := Options{}
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt(&o); err != nil {
+				return err
+			}
+		}
+	}
+	if err := o.checkAuth(); err != nil {
+		return err
+	}
+	force, err := nc.reconnectForUpdate(force)
+	if err != nil {
+		return err
+	}
+	nc.Opts.User = o.User
+	nc.Opts.Password = o.Password
+	nc.Opts.Token = o.Token
+	nc.Opts.TokenHandler = o.TokenHandler
+	nc.Opts.UserJWT = o.UserJWT
+	nc.Opts.Nkey = o.Nkey
+	nc.Opts.SignatureCB = o.SignatureCB
+	if force {
+		nc.forceReconnect()
+	}
+	return nil
+}
+
+// UpdateTLSConfig replaces the TLS configuration of the connection, which
+// is then secure. The configuration must not be modified after the call.
+//
+// The new configuration is used from the next reconnect, unless `force`
+// is true, in which case the connection reconnects, as with Conn.Reconnect,
+// to apply it immediately.
+func (nc *Conn) UpdateTLSConfig(config *tls.Config, force bool) error {
+	if config == nil {
+		return ErrInvalidArg
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		return ErrConnectionClosed
+	}
+	force, err := nc.reconnectForUpdate(force)
+	if err != nil {
+		return err
+	}
+	nc.Opts.Secure = true
+	nc.Opts.TLSConfig = config
+	if force {
+		nc.forceReconnect()
+	}
+	return nil
+}
+
+// reconnectForUpdate checks that a reconnect can be forced to apply an
+// update, and returns whether it is needed: if the connection is already
+// reconnecting, the update is used by the next attempt.
+// Lock should be held.
+func (nc *Conn) reconnectForUpdate(force bool) (bool, error) {
+	if !force {
+		return false, nil
+	}
+	switch err := nc.canForceReconnect(); err {
+	case nil:
+		return true, nil
+	case ErrConnectionReconnecting:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// checkAuth returns an error if the authentication settings conflict,
+// as checked by Options.Connect and the options setting them.
+func (o *Options) checkAuth() error {
+	switch {
+	case o.UserJWT != nil && o.Nkey != "":
+		return ErrNkeyAndUser
+	case o.Nkey != "" && o.SignatureCB == nil:
+		return ErrNkeyButNoSigCB
+	case o.Token != "" && o.TokenHandler != nil:
+		return ErrTokenAlreadySet
+	}
+	return nil
+}
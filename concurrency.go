@@ -0,0 +1,146 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// workerQueueLen is the number of messages queued per worker
+// of a concurrent subscription.
+const workerQueueLen = 64
+
+// MsgKeyFunc extracts the ordering key of a message, see SetConcurrency.
+type MsgKeyFunc func(m *Msg) string
+
+// SubjectTokenKey returns a MsgKeyFunc whose key is the subject token at
+// the given zero-based index, or an empty key if the subject has fewer tokens.
+func SubjectTokenKey(index int) MsgKeyFunc {
+	return func(m *Msg) string {
+		tokens := strings.Split(m.Subject, ".")
+		if index < 0 || index >= len(tokens) {
+			return _EMPTY_
+		}
+		return tokens[index]
+	}
+}
+
+// HeaderKey returns a MsgKeyFunc whose key is the value of the given header.
+func HeaderKey(name string) MsgKeyFunc {
+	return func(m *Msg) string {
+		return m.Header.Get(name)
+	}
+}
+
+// SetConcurrency makes an asynchronous subscription invoke its callback
+// from `workers` goroutines. If `key` is not nil, messages with the same
+// key are delivered in order, by the same worker. Otherwise, messages are
+// delivered to any available worker, in no specific order.
+// Messages remain pending, with regards to the pending limits and slow
+// consumer detection, until their callback returns.
+// Setting a single worker, without key, restores the default behavior.
+func (s *Subscription) SetConcurrency(workers int, key MsgKeyFunc) error {
+	if s == nil {
+		return ErrBadSubscription
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil || s.closed {
+		return ErrBadSubscription
+	}
+	if s.typ != AsyncSubscription {
+		return ErrTypeSubscription
+	}
+	if workers < 1 {
+		return ErrInvalidArg
+	}
+	if workers == 1 && key == nil {
+		s.dispatcher = nil
+	} else {
+		s.dispatcher = &subDispatcher{workers: workers, key: key}
+	}
+	return nil
+}
+
+// subDispatcher delivers the messages of a subscription to workers.
+// Only the subscription's waitForMsgs goroutine invokes its methods.
+type subDispatcher struct {
+	workers int
+	key     MsgKeyFunc
+	queues  []chan *Msg
+	// Messages dispatched and not yet processed.
+	inflight sync.WaitGroup
+	// Running workers.
+	running sync.WaitGroup
+}
+
+func (d *subDispatcher) start(s *Subscription, mcb MsgHandler) {
+	n := 1
+	if d.key != nil {
+		n = d.workers
+	}
+	d.queues = make([]chan *Msg, n)
+	for i := range d.queues {
+		d.queues[i] = make(chan *Msg, workerQueueLen)
+	}
+	d.running.Add(d.workers)
+	for i := 0; i < d.workers; i++ {
+		go d.work(s, mcb, d.queues[i%n])
+	}
+}
+
+func (d *subDispatcher) work(s *Subscription, mcb MsgHandler, queue chan *Msg) {
+	defer d.running.Done()
+	for m := range queue {
+		msgLen := len(m.Data)
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if !closed {
+			mcb(m)
+		}
+		s.mu.Lock()
+		s.pMsgs--
+		s.pBytes -= msgLen
+		s.mu.Unlock()
+		d.inflight.Done()
+	}
+}
+
+func (d *subDispatcher) dispatch(m *Msg) {
+	d.inflight.Add(1)
+	if len(d.queues) == 1 {
+		d.queues[0] <- m
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(d.key(m)))
+	d.queues[h.Sum32()%uint32(len(d.queues))] <- m
+}
+
+// wait waits for all dispatched messages to be processed.
+func (d *subDispatcher) wait() {
+	d.inflight.Wait()
+}
+
+// stop waits for the dispatched messages to be processed
+// and for the workers to exit.
+func (d *subDispatcher) stop() {
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.running.Wait()
+}
@@ -16,8 +16,22 @@ package nats
 import (
 	"context"
 	"reflect"
+	"time"
 )
 
+// PublishMsgWithContext publishes the Msg structure with the given context,
+// which is handed to the connection's Tracer, if any, to propagate
+// the trace context through the message headers.
+func (nc *Conn) PublishMsgWithContext(ctx context.Context, m *Msg) error {
+	if ctx == nil {
+		return ErrInvalidContext
+	}
+	if m == nil {
+		return ErrInvalidMsg
+	}
+	return nc.publishMsgWithContext(ctx, m)
+}
+
 // RequestMsgWithContext takes a context, a subject and payload
 // in bytes and request expecting a single response.
 func (nc *Conn) RequestMsgWithContext(ctx context.Context, msg *Msg) (*Msg, error) {
@@ -44,7 +58,7 @@ func (nc *Conn) RequestWithContext(ctx context.Context, subj string, data []byte
 	return nc.requestWithContext(ctx, subj, nil, data)
 }
 
-func (nc *Conn) requestWithContext(ctx context.Context, subj string, hdr, data []byte) (*Msg, error) {
+func (nc *Conn) requestWithContext(ctx context.Context, subj string, hdr, data []byte) (m *Msg, err error) {
 	if ctx == nil {
 		return nil, ErrInvalidContext
 	}
@@ -57,6 +71,20 @@ func (nc *Conn) requestWithContext(ctx context.Context, subj string, hdr, data [
 		return nil, ctx.Err()
 	}
 
+	if nc.metrics != nil {
+		defer func(start time.Time) { nc.metrics.requestDone(start, err) }(time.Now())
+	}
+
+	if nc.reqPolicy != nil {
+		return nc.reqPolicy.do(ctx, subj, func(ctx context.Context) (*Msg, error) {
+			return nc.requestWithContextOnce(ctx, subj, hdr, data)
+		})
+	}
+	return nc.requestWithContextOnce(ctx, subj, hdr, data)
+}
+
+// requestWithContextOnce performs a single request attempt.
+func (nc *Conn) requestWithContextOnce(ctx context.Context, subj string, hdr, data []byte) (*Msg, error) {
 	var m *Msg
 	var err error
 
@@ -64,7 +92,7 @@ func (nc *Conn) requestWithContext(ctx context.Context, subj string, hdr, data [
 	if nc.useOldRequestStyle() {
 		m, err = nc.oldRequestWithContext(ctx, subj, hdr, data)
 	} else {
-		mch, token, err := nc.createNewRequestAndSend(subj, hdr, data)
+		mch, token, err := nc.createNewRequestAndSend(ctx, subj, hdr, data)
 		if err != nil {
 			return nil, err
 		}
@@ -95,14 +123,14 @@ func (nc *Conn) oldRequestWithContext(ctx context.Context, subj string, hdr, dat
 	inbox := nc.newInbox()
 	ch := make(chan *Msg, RequestChanLen)
 
-	s, err := nc.subscribe(inbox, _EMPTY_, nil, ch, true, nil)
+	s, err := nc.subscribeRequestInbox(inbox, ch)
 	if err != nil {
 		return nil, err
 	}
 	s.AutoUnsubscribe(1)
 	defer s.Unsubscribe()
 
-	err = nc.publish(subj, inbox, hdr, data)
+	err = nc.publishWithContext(ctx, subj, inbox, hdr, data)
 	if err != nil {
 		return nil, err
 	}
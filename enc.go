@@ -206,11 +206,9 @@ func (c *EncodedConn) subscribe(subject, queue string, cb Handler) (*Subscriptio
 				oPtr = reflect.New(argType.Elem())
 			}
 			if err := c.Enc.Decode(m.Subject, m.Data, oPtr.Interface()); err != nil {
-				if c.Conn.Opts.AsyncErrorCB != nil {
-					c.Conn.ach.push(func() {
-						c.Conn.Opts.AsyncErrorCB(c.Conn, m.Sub, errors.New("nats: Got an error trying to unmarshal: "+err.Error()))
-					})
-				}
+				c.Conn.mu.Lock()
+				c.Conn.asyncError(m.Sub, errors.New("nats: Got an error trying to unmarshal: "+err.Error()))
+				c.Conn.mu.Unlock()
 				return
 			}
 			if argType.Kind() != reflect.Ptr {
@@ -0,0 +1,134 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+// PublishFunc sends a message to the server. It is the type of the
+// next step of a publish interceptor chain.
+type PublishFunc func(m *Msg) error
+
+// PublishInterceptor is invoked for every message published on a connection,
+// including requests and replies. The interceptor can inspect or modify the
+// message (subject, reply, headers and data) before handing it to `next`, or
+// reject it by returning an error without invoking `next`, in which case the
+// error is returned to the publisher. The message is a copy of the one given
+// to PublishMsg, with its own headers, but the data must not be modified
+// in place.
+type PublishInterceptor func(m *Msg, next PublishFunc) error
+
+// DeliveryInterceptor is invoked for every message delivered to a
+// subscription, before the subscription's MsgHandler, or before the message
+// is queued for NextMsg or sent to the channel of the subscription.
+// The interceptor can inspect or modify the message before handing it
+// to `next`, or drop it by simply not invoking `next`.
+//
+// For synchronous and channel based subscriptions, the interceptor is
+// invoked from the connection's read loop, so it should not block, and
+// `next` has to be invoked before returning, otherwise the message is
+// dropped. The internal subscriptions receiving the responses to requests
+// are not affected.
+type DeliveryInterceptor func(m *Msg, next MsgHandler)
+
+// PublishInterceptors is an Option to add interceptors that will be
+// invoked for every message published on the connection. Interceptors
+// are invoked in the order they are given, the first one being the
+// outermost.
+func PublishInterceptors(interceptors ...PublishInterceptor) Option {
+	return func(o *Options) error {
+		for _, i := range interceptors {
+			if i == nil {
+				return ErrInvalidArg
+			}
+		}
+		o.PublishInterceptors = append(o.PublishInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// DeliveryInterceptors is an Option to add interceptors that will be
+// invoked for every message delivered to a subscription.
+// Interceptors are invoked in the order they are given, the first one
+// being the outermost.
+func DeliveryInterceptors(interceptors ...DeliveryInterceptor) Option {
+	return func(o *Options) error {
+		for _, i := range interceptors {
+			if i == nil {
+				return ErrInvalidArg
+			}
+		}
+		o.DeliveryInterceptors = append(o.DeliveryInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// chainPublishInterceptors returns a PublishFunc that will invoke the
+// interceptors in order and end with `final`.
+func chainPublishInterceptors(interceptors []PublishInterceptor, final PublishFunc) PublishFunc {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		pi, pn := interceptors[i], next
+		next = func(m *Msg) error { return pi(m, pn) }
+	}
+	return next
+}
+
+// chainDeliveryInterceptors returns a MsgHandler that will invoke the
+// interceptors in order and end with the subscription's handler `cb`.
+func chainDeliveryInterceptors(interceptors []DeliveryInterceptor, cb MsgHandler) MsgHandler {
+	next := cb
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		di, dn := interceptors[i], next
+		next = func(m *Msg) { di(m, dn) }
+	}
+	return next
+}
+
+// interceptMsg invokes the delivery interceptors for a message of a
+// synchronous or channel based subscription, returning the message handed
+// to the end of the chain, or nil if it was dropped.
+func interceptMsg(interceptors []DeliveryInterceptor, m *Msg) *Msg {
+	var delivered *Msg
+	chainDeliveryInterceptors(interceptors, func(m *Msg) { delivered = m })(m)
+	return delivered
+}
+
+// publishInterceptedMsg is the end of the publish interceptor chain.
+// It encodes the possibly modified headers and sends the message.
+func (nc *Conn) publishInterceptedMsg(m *Msg) error {
+	if m == nil {
+		return ErrInvalidMsg
+	}
+	var hdr []byte
+	if len(m.Header) > 0 {
+		if !nc.info.Headers {
+			return ErrHeadersNotSupported
+		}
+		var err error
+		if hdr, err = m.headerBytes(); err != nil {
+			return err
+		}
+	}
+	return nc.publishProto(m.Subject, m.Reply, hdr, m.Data)
+}
+
+// cloneHeader returns a copy of the header, or nil if empty.
+func cloneHeader(h Header) Header {
+	if h == nil {
+		return nil
+	}
+	c := make(Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,197 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultReconnectJournalMaxBytes is the default size limit of the
+// reconnect journal.
+const DefaultReconnectJournalMaxBytes = 256 * 1024 * 1024 // 256MB
+
+var (
+	ErrReconnectJournalFull    = errors.New("nats: reconnect journal size limit exceeded")
+	ErrReconnectJournalExpired = errors.New("nats: reconnect journal data expired")
+)
+
+// ReconnectJournal configures an on-disk journal to which the reconnect
+// buffer is spilled when it reaches ReconnectBufSize, instead of failing
+// publish calls with ErrReconnectBufExceeded. The journal is replayed in
+// order, before the content of the reconnect buffer, once reconnected.
+// Data is journaled in segments of about ReconnectBufSize bytes, which
+// are the unit of discard.
+type ReconnectJournal struct {
+	// Dir is the directory in which the journal of the connection is
+	// created, in its own sub-directory. Defaults to os.TempDir().
+	Dir string
+
+	// MaxBytes is the size limit of the journal. When exceeded, the
+	// oldest segments are discarded. Defaults to DefaultReconnectJournalMaxBytes.
+	MaxBytes int64
+
+	// MaxAge, if set, is the time after which journaled segments are
+	// discarded instead of being replayed.
+	MaxAge time.Duration
+
+	// DiscardedCB, if set, is invoked asynchronously with the size of the
+	// discarded data and the reason, which is ErrReconnectJournalFull,
+	// ErrReconnectJournalExpired, ErrConnectionClosed if the connection is
+	// closed before the data is replayed, or the error that prevented
+	// journaling or replaying the data.
+	DiscardedCB func(nc *Conn, bytes int64, reason error)
+}
+
+// SetReconnectJournal is an Option to spill the reconnect buffer to disk.
+func SetReconnectJournal(journal ReconnectJournal) Option {
+	return func(o *Options) error {
+		if journal.MaxBytes < 0 || journal.MaxAge < 0 {
+			return ErrInvalidArg
+		}
+		o.ReconnectJournal = &journal
+		return nil
+	}
+}
+
+// reconnectJournal is the on-disk extension of the natsWriter's
+// pending buffer. It is protected by the connection's lock.
+type reconnectJournal struct {
+	dir      string
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+	discard  func(bytes int64, reason error)
+	segments []journalSegment
+	size     int64
+	seq      uint64
+}
+
+type journalSegment struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+func (nc *Conn) newReconnectJournal() *reconnectJournal {
+	cfg := nc.Opts.ReconnectJournal
+	j := &reconnectJournal{
+		dir:      cfg.Dir,
+		maxBytes: cfg.MaxBytes,
+		maxAge:   cfg.MaxAge,
+	}
+	if j.dir == _EMPTY_ {
+		j.dir = os.TempDir()
+	}
+	if j.maxBytes == 0 {
+		j.maxBytes = DefaultReconnectJournalMaxBytes
+	}
+	j.discard = func(bytes int64, reason error) {
+		nc.log(LogLevelWarn, "reconnect journal data discarded", "bytes", bytes, "error", reason)
+		if cb := cfg.DiscardedCB; cb != nil {
+			nc.ach.push(func() { cb(nc, bytes, reason) })
+		}
+	}
+	return j
+}
+
+// spill writes `buf` as a new segment, then enforces the limits.
+func (j *reconnectJournal) spill(buf []byte) {
+	if j.path == _EMPTY_ {
+		path, err := ioutil.TempDir(j.dir, "nats-journal-")
+		if err != nil {
+			j.discard(int64(len(buf)), err)
+			return
+		}
+		j.path = path
+	}
+	j.seq++
+	path := filepath.Join(j.path, fmt.Sprintf("%020d.seg", j.seq))
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		os.Remove(path)
+		j.discard(int64(len(buf)), err)
+		return
+	}
+	j.segments = append(j.segments, journalSegment{path: path, size: int64(len(buf)), created: time.Now()})
+	j.size += int64(len(buf))
+	j.expire()
+	for j.size > j.maxBytes && len(j.segments) > 0 {
+		j.drop(ErrReconnectJournalFull)
+	}
+}
+
+// expire discards the segments older than the maximum age.
+func (j *reconnectJournal) expire() {
+	if j.maxAge == 0 {
+		return
+	}
+	for len(j.segments) > 0 && time.Since(j.segments[0].created) > j.maxAge {
+		j.drop(ErrReconnectJournalExpired)
+	}
+}
+
+// drop removes the oldest segment.
+func (j *reconnectJournal) drop(reason error) {
+	seg := j.segments[0]
+	j.segments = j.segments[1:]
+	j.size -= seg.size
+	os.Remove(seg.path)
+	if reason != nil {
+		j.discard(seg.size, reason)
+	}
+}
+
+// replay writes the segments, in order, to `w`. A segment that could not
+// be fully written is discarded, the remaining ones are kept for the
+// next replay.
+func (j *reconnectJournal) replay(w io.Writer) error {
+	for {
+		done, err := j.replaySegment(w)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// replaySegment writes the oldest segment to `w`, and reports whether
+// there is no more segment to replay.
+func (j *reconnectJournal) replaySegment(w io.Writer) (bool, error) {
+	j.expire()
+	if len(j.segments) == 0 {
+		return true, nil
+	}
+	buf, err := ioutil.ReadFile(j.segments[0].path)
+	if err == nil {
+		_, err = w.Write(buf)
+	}
+	j.drop(err)
+	return len(j.segments) == 0, err
+}
+
+// remove deletes the journal files, reporting the data not replayed.
+func (j *reconnectJournal) remove() {
+	if j.size > 0 {
+		j.discard(j.size, ErrConnectionClosed)
+	}
+	if j.path != _EMPTY_ {
+		os.RemoveAll(j.path)
+		j.path = _EMPTY_
+	}
+	j.segments, j.size = nil, 0
+}
@@ -415,10 +415,14 @@ func (js *js) PublishMsg(m *Msg, opts ...PubOpt) (*PubAck, error) {
 	var resp *Msg
 	var err error
 
+	start := time.Now()
 	if o.ttl > 0 {
-		resp, err = js.nc.RequestMsg(m, time.Duration(o.ttl))
+		resp, err = js.nc.jsRequest(nil, m, time.Duration(o.ttl))
 	} else {
-		resp, err = js.nc.RequestMsgWithContext(o.ctx, m)
+		resp, err = js.nc.jsRequest(o.ctx, m, 0)
+	}
+	if cm := js.nc.metrics; cm != nil && err == nil {
+		cm.jsPubAck.observe(time.Since(start))
 	}
 
 	if err != nil {
@@ -645,6 +649,9 @@ func (js *js) handleAsyncReply(m *Msg) {
 	}
 
 	// So here we have received a proper puback.
+	if cm := js.nc.metrics; cm != nil {
+		cm.jsPubAck.observe(time.Since(paf.st))
+	}
 	paf.pa = pa.PubAck
 	if paf.doneCh != nil {
 		paf.doneCh <- paf.pa
@@ -1465,7 +1472,7 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync,
 		if js.opts.shouldTrace {
 			js.opts.trace(TraceSent, ccSubj, j, nil)
 		}
-		resp, err := nc.Request(ccSubj, j, js.opts.wait)
+		resp, err := nc.jsRequest(nil, &Msg{Subject: ccSubj, Data: j}, js.opts.wait)
 		if err != nil {
 			cleanUpSub()
 			if err == ErrNoResponders {
@@ -1774,7 +1781,7 @@ func (sub *Subscription) resetOrderedConsumer(sseq uint64) {
 			return
 		}
 
-		resp, err := nc.Request(js.apiSubj(ccSubj), j, js.opts.wait)
+		resp, err := nc.jsRequest(nil, &Msg{Subject: js.apiSubj(ccSubj), Data: j}, js.opts.wait)
 		if err != nil {
 			if err == ErrNoResponders {
 				err = ErrJetStreamNotEnabled
@@ -1851,9 +1858,7 @@ func (sub *Subscription) activityCheck() {
 
 	if !active {
 		nc.mu.Lock()
-		if errCB := nc.Opts.AsyncErrorCB; errCB != nil {
-			nc.ach.push(func() { errCB(nc, sub, ErrConsumerNotActive) })
-		}
+		nc.asyncError(sub, ErrConsumerNotActive)
 		nc.mu.Unlock()
 	}
 }
@@ -1879,10 +1884,7 @@ func (sub *Subscription) scheduleHeartbeatCheck() {
 // handleConsumerSequenceMismatch will send an async error that can be used to restart a push based consumer.
 func (nc *Conn) handleConsumerSequenceMismatch(sub *Subscription, err error) {
 	nc.mu.Lock()
-	errCB := nc.Opts.AsyncErrorCB
-	if errCB != nil {
-		nc.ach.push(func() { errCB(nc, sub, err) })
-	}
+	nc.asyncError(sub, err)
 	nc.mu.Unlock()
 }
 
@@ -1949,7 +1951,7 @@ func (js *js) lookupStreamBySubject(subj string) (string, error) {
 	if err != nil {
 		return _EMPTY_, err
 	}
-	resp, err := js.nc.Request(js.apiSubj(apiStreams), j, js.opts.wait)
+	resp, err := js.nc.jsRequest(nil, &Msg{Subject: js.apiSubj(apiStreams), Data: j}, js.opts.wait)
 	if err != nil {
 		if err == ErrNoResponders {
 			err = ErrJetStreamNotEnabled
@@ -2525,10 +2527,13 @@ func (js *js) getConsumerInfoContext(ctx context.Context, stream, consumer strin
 
 // a RequestWithContext with tracing via TraceCB
 func (js *js) apiRequestWithContext(ctx context.Context, subj string, data []byte) (*Msg, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
 	if js.opts.shouldTrace {
 		js.opts.trace(TraceSent, subj, data, nil)
 	}
-	resp, err := js.nc.RequestWithContext(ctx, subj, data)
+	resp, err := js.nc.jsRequest(ctx, &Msg{Subject: subj, Data: data}, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -2602,10 +2607,14 @@ func (m *Msg) ackReply(ackType []byte, sync bool, opts ...AckOpt) error {
 	}
 
 	if sync {
+		start := time.Now()
 		if usesCtx {
-			_, err = nc.RequestWithContext(ctx, m.Reply, ackType)
+			_, err = nc.jsRequest(ctx, &Msg{Subject: m.Reply, Data: ackType}, 0)
 		} else {
-			_, err = nc.Request(m.Reply, ackType, wait)
+			_, err = nc.jsRequest(nil, &Msg{Subject: m.Reply, Data: ackType}, wait)
+		}
+		if nc.metrics != nil && err == nil {
+			nc.metrics.jsAck.observe(time.Since(start))
 		}
 	} else {
 		err = nc.Publish(m.Reply, ackType)
@@ -0,0 +1,173 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of an entry emitted through a Logger.
+type LogLevel int
+
+const (
+	LogLevelDebug = LogLevel(iota)
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	}
+	return "unknown log level"
+}
+
+// Logger is used to emit structured log entries for connection lifecycle
+// events (connect, disconnect, reconnect attempts, server discovery, lame
+// duck mode, stale connection, close) and for asynchronous errors, such as
+// slow consumers, which are then passed to the AsyncErrorCB, if any.
+//
+// The keysAndValues arguments are alternating keys (strings) and values.
+// The logger may be invoked while the connection's lock is held, so it
+// must not call back into the connection and should not block.
+type Logger interface {
+	Log(level LogLevel, msg string, keysAndValues ...interface{})
+}
+
+// LoggerFunc is an adapter to allow the use of an ordinary function as
+// a Logger, for instance to bridge to an existing logging library.
+type LoggerFunc func(level LogLevel, msg string, keysAndValues ...interface{})
+
+// Log implements the Logger interface.
+func (f LoggerFunc) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	f(level, msg, keysAndValues...)
+}
+
+// SetLogger is an Option to set the Logger used to report connection
+// lifecycle events and asynchronous errors.
+func SetLogger(logger Logger) Option {
+	return func(o *Options) error {
+		o.Logger = logger
+		return nil
+	}
+}
+
+// NewLogger returns a Logger that writes entries in logfmt format
+// (`time=... level=INFO msg="..." key=value ...`) to the given writer.
+// Entries with a level lower than `level` are discarded.
+// The returned Logger is safe for concurrent use.
+func NewLogger(w io.Writer, level LogLevel) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level LogLevel
+	buf   []byte
+}
+
+func (l *textLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buf[:0]
+	b = append(b, "time="...)
+	b = time.Now().UTC().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, " level="...)
+	b = append(b, level.String()...)
+	b = append(b, " msg="...)
+	b = appendLogValue(b, msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b = append(b, ' ')
+		b = append(b, fmt.Sprint(keysAndValues[i])...)
+		b = append(b, '=')
+		if i+1 < len(keysAndValues) {
+			b = appendLogValue(b, fmt.Sprint(keysAndValues[i+1]))
+		} else {
+			b = append(b, `""`...)
+		}
+	}
+	b = append(b, '\n')
+	l.w.Write(b)
+	l.buf = b
+}
+
+// appendLogValue appends the value, quoting it if necessary.
+func appendLogValue(b []byte, v string) []byte {
+	if v == _EMPTY_ || strings.ContainsAny(v, " \t\r\n\"=") {
+		return strconv.AppendQuote(b, v)
+	}
+	return append(b, v...)
+}
+
+// log emits an entry through the configured Logger, if any.
+// The connection's lock may be held on entry.
+func (nc *Conn) log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	if l := nc.Opts.Logger; l != nil {
+		l.Log(level, msg, keysAndValues...)
+	}
+}
+
+// asyncError logs the asynchronous error, then passes it to the
+// AsyncErrorCB, if any, through the async callbacks dispatcher.
+// Lock should be held, but not the subscription's lock.
+func (nc *Conn) asyncError(sub *Subscription, err error) {
+	nc.logAsyncError(sub, err)
+	if errCB := nc.Opts.AsyncErrorCB; errCB != nil {
+		nc.ach.push(func() { errCB(nc, sub, err) })
+	}
+}
+
+// logAsyncError emits the asynchronous error through the Logger, if any.
+// Lock should be held, but not the subscription's lock.
+func (nc *Conn) logAsyncError(sub *Subscription, err error) {
+	l := nc.Opts.Logger
+	if l == nil {
+		return
+	}
+	level := LogLevelError
+	if err == ErrSlowConsumer {
+		level = LogLevelWarn
+	}
+	kv := []interface{}{"cid", nc.info.CID, "error", err}
+	if sub != nil {
+		kv = append(kv, "subject", sub.errSubject())
+	}
+	l.Log(level, "asynchronous error", kv...)
+}
+
+// currentURL returns the URL of the current server, or an empty string.
+// Lock should be held.
+func (nc *Conn) currentURL() string {
+	if nc.current == nil {
+		return _EMPTY_
+	}
+	return nc.current.url.String()
+}
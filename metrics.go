@@ -0,0 +1,495 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMetricsSubjectTokens is the default number of subject tokens
+	// used to aggregate per-subject metrics.
+	DefaultMetricsSubjectTokens = 1
+
+	// maxMetricsSubjects limits the number of distinct subject prefixes
+	// that are tracked. Once reached, other prefixes are aggregated
+	// under metricsOtherSubjects.
+	maxMetricsSubjects   = 1024
+	metricsOtherSubjects = "_other_"
+
+	// Number of RTT samples that are kept.
+	metricsRTTHistory = 32
+)
+
+// Upper bounds of the latency histograms buckets.
+var metricsBuckets = [...]time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// EnableMetrics is an Option to enable the collection of client metrics,
+// which are available through Conn.Metrics() and Conn.MetricsHandler().
+// Publish and delivery counts are aggregated per subject prefix made of the
+// first `subjectTokens` tokens (DefaultMetricsSubjectTokens if 0 or less).
+func EnableMetrics(subjectTokens int) Option {
+	return func(o *Options) error {
+		o.Metrics = true
+		o.MetricsSubjectTokens = subjectTokens
+		return nil
+	}
+}
+
+// Metrics is a snapshot of the connection's metrics.
+// Except for Statistics and Subscriptions, fields are only
+// populated when metrics are enabled with EnableMetrics().
+type Metrics struct {
+	Statistics
+
+	// Subjects holds publish and delivery counts per subject prefix.
+	Subjects map[string]SubjectMetrics
+
+	// Subscriptions holds the counters of the connection's subscriptions.
+	Subscriptions []SubscriptionMetrics
+
+	// RequestLatency is the latency of successful requests.
+	RequestLatency HistogramSnapshot
+	// RequestErrors is the number of requests that failed, including timeouts.
+	RequestErrors uint64
+
+	// RTT is the distribution of the round trip times measured by the
+	// client's pings, and RTTHistory the most recent samples, oldest first.
+	RTT        HistogramSnapshot
+	RTTHistory []time.Duration
+
+	// ReconnectDuration is the time taken to re-establish the connection
+	// after a disconnect.
+	ReconnectDuration HistogramSnapshot
+
+	// SlowConsumers is the number of times a subscription became
+	// a slow consumer.
+	SlowConsumers uint64
+
+	// JSPublishAckLatency is the latency of JetStream publish acks, and
+	// JSAckLatency the latency of synchronous JetStream message acks.
+	JSPublishAckLatency HistogramSnapshot
+	JSAckLatency        HistogramSnapshot
+}
+
+// SubjectMetrics holds the counters for a subject prefix.
+type SubjectMetrics struct {
+	OutMsgs  uint64
+	OutBytes uint64
+	InMsgs   uint64
+	InBytes  uint64
+}
+
+// SubscriptionMetrics holds the counters of a subscription.
+type SubscriptionMetrics struct {
+	Subject      string
+	Queue        string
+	Delivered    uint64
+	Dropped      int
+	PendingMsgs  int
+	PendingBytes int
+}
+
+// HistogramSnapshot is a snapshot of a latency histogram.
+type HistogramSnapshot struct {
+	// Buckets are the upper bounds of the buckets, and Counts the
+	// number of observations for each of them. Counts has an extra
+	// element for observations larger than the last bound.
+	Buckets []time.Duration
+	Counts  []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// connMetrics is the collector for a connection. All counters are updated
+// with atomic operations, except for the fields documented otherwise that
+// are protected by the connection's lock.
+type connMetrics struct {
+	// Keep the 64-bit atomics first for alignment on 32-bit platforms.
+	slowConsumers uint64
+	reqErrors     uint64
+	reqLatency    histogram
+	rtt           histogram
+	reconnect     histogram
+	jsPubAck      histogram
+	jsAck         histogram
+
+	tokens   int
+	subjects sync.Map // string -> *subjectCounters
+	nsubj    int32
+
+	// Protected by the connection's lock.
+	pings   []time.Time
+	rtts    [metricsRTTHistory]time.Duration
+	rttsLen int
+	rttsIdx int
+}
+
+type subjectCounters struct {
+	outMsgs  uint64
+	outBytes uint64
+	inMsgs   uint64
+	inBytes  uint64
+}
+
+type histogram struct {
+	counts [len(metricsBuckets) + 1]uint64
+	count  uint64
+	sum    uint64
+}
+
+func newConnMetrics(tokens int) *connMetrics {
+	if tokens <= 0 {
+		tokens = DefaultMetricsSubjectTokens
+	}
+	return &connMetrics{tokens: tokens}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(metricsBuckets), func(i int) bool { return d <= metricsBuckets[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	hs := HistogramSnapshot{
+		Buckets: metricsBuckets[:],
+		Counts:  make([]uint64, len(h.counts)),
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadUint64(&h.sum)),
+	}
+	for i := range h.counts {
+		hs.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return hs
+}
+
+// subjectPrefix returns the first `tokens` tokens of the subject.
+func subjectPrefix(subj string, tokens int) string {
+	for i := 0; i < len(subj); i++ {
+		if subj[i] == '.' {
+			if tokens--; tokens == 0 {
+				return subj[:i]
+			}
+		}
+	}
+	return subj
+}
+
+func (cm *connMetrics) counters(subj string) *subjectCounters {
+	prefix := subjectPrefix(subj, cm.tokens)
+	if sc, ok := cm.subjects.Load(prefix); ok {
+		return sc.(*subjectCounters)
+	}
+	if atomic.AddInt32(&cm.nsubj, 1) > maxMetricsSubjects {
+		atomic.AddInt32(&cm.nsubj, -1)
+		prefix = metricsOtherSubjects
+	}
+	sc, loaded := cm.subjects.LoadOrStore(prefix, &subjectCounters{})
+	if loaded && prefix != metricsOtherSubjects {
+		atomic.AddInt32(&cm.nsubj, -1)
+	}
+	return sc.(*subjectCounters)
+}
+
+func (cm *connMetrics) published(subj string, size int) {
+	sc := cm.counters(subj)
+	atomic.AddUint64(&sc.outMsgs, 1)
+	atomic.AddUint64(&sc.outBytes, uint64(size))
+}
+
+func (cm *connMetrics) delivered(subj string, size int) {
+	sc := cm.counters(subj)
+	atomic.AddUint64(&sc.inMsgs, 1)
+	atomic.AddUint64(&sc.inBytes, uint64(size))
+}
+
+func (cm *connMetrics) requestDone(start time.Time, err error) {
+	if err != nil {
+		atomic.AddUint64(&cm.reqErrors, 1)
+		return
+	}
+	cm.reqLatency.observe(time.Since(start))
+}
+
+// pingSent records the time a ping was sent. A zero time is recorded
+// if the ping is not sent right away, for instance while reconnecting.
+// Connection lock is held on entry.
+func (cm *connMetrics) pingSent(now bool) {
+	var t time.Time
+	if now {
+		t = time.Now()
+	}
+	cm.pings = append(cm.pings, t)
+}
+
+// pongReceived records the RTT for the oldest outstanding ping.
+// Connection lock is held on entry.
+func (cm *connMetrics) pongReceived() {
+	if len(cm.pings) == 0 {
+		return
+	}
+	t := cm.pings[0]
+	cm.pings = append(cm.pings[:0], cm.pings[1:]...)
+	if t.IsZero() {
+		return
+	}
+	rtt := time.Since(t)
+	cm.rtt.observe(rtt)
+	cm.rtts[cm.rttsIdx] = rtt
+	cm.rttsIdx = (cm.rttsIdx + 1) % metricsRTTHistory
+	if cm.rttsLen < metricsRTTHistory {
+		cm.rttsLen++
+	}
+}
+
+// Connection lock is held on entry.
+func (cm *connMetrics) clearPings() {
+	cm.pings = cm.pings[:0]
+}
+
+// Connection lock is held on entry.
+func (cm *connMetrics) rttHistory() []time.Duration {
+	rtts := make([]time.Duration, 0, cm.rttsLen)
+	start := cm.rttsIdx - cm.rttsLen
+	if start < 0 {
+		start += metricsRTTHistory
+	}
+	for i := 0; i < cm.rttsLen; i++ {
+		rtts = append(rtts, cm.rtts[(start+i)%metricsRTTHistory])
+	}
+	return rtts
+}
+
+// Metrics returns a snapshot of the connection's metrics.
+func (nc *Conn) Metrics() Metrics {
+	m := Metrics{Statistics: nc.Stats()}
+
+	nc.mu.RLock()
+	cm := nc.metrics
+	if cm != nil {
+		m.RTTHistory = cm.rttHistory()
+	}
+	nc.mu.RUnlock()
+
+	nc.subsMu.RLock()
+	m.Subscriptions = make([]SubscriptionMetrics, 0, len(nc.subs))
+	for _, sub := range nc.subs {
+		sub.mu.Lock()
+		m.Subscriptions = append(m.Subscriptions, SubscriptionMetrics{
+			Subject:      sub.Subject,
+			Queue:        sub.Queue,
+			Delivered:    sub.delivered,
+			Dropped:      sub.dropped,
+			PendingMsgs:  sub.pMsgs,
+			PendingBytes: sub.pBytes,
+		})
+		sub.mu.Unlock()
+	}
+	nc.subsMu.RUnlock()
+	sort.Slice(m.Subscriptions, func(i, j int) bool {
+		return m.Subscriptions[i].Subject < m.Subscriptions[j].Subject
+	})
+
+	if cm == nil {
+		return m
+	}
+	m.Subjects = make(map[string]SubjectMetrics)
+	cm.subjects.Range(func(k, v interface{}) bool {
+		sc := v.(*subjectCounters)
+		m.Subjects[k.(string)] = SubjectMetrics{
+			OutMsgs:  atomic.LoadUint64(&sc.outMsgs),
+			OutBytes: atomic.LoadUint64(&sc.outBytes),
+			InMsgs:   atomic.LoadUint64(&sc.inMsgs),
+			InBytes:  atomic.LoadUint64(&sc.inBytes),
+		}
+		return true
+	})
+	m.RequestLatency = cm.reqLatency.snapshot()
+	m.RequestErrors = atomic.LoadUint64(&cm.reqErrors)
+	m.RTT = cm.rtt.snapshot()
+	m.ReconnectDuration = cm.reconnect.snapshot()
+	m.SlowConsumers = atomic.LoadUint64(&cm.slowConsumers)
+	m.JSPublishAckLatency = cm.jsPubAck.snapshot()
+	m.JSAckLatency = cm.jsAck.snapshot()
+	return m
+}
+
+// MetricsHandler returns an http.Handler that renders the connection's
+// metrics in the Prometheus text exposition format.
+func (nc *Conn) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		nc.Metrics().writePrometheus(bw)
+		bw.Flush()
+	})
+}
+
+const metricsNamespace = "nats_client_"
+
+type promWriter struct {
+	w *bufio.Writer
+}
+
+func (pw promWriter) header(name, typ, help string) {
+	pw.w.WriteString("# HELP " + metricsNamespace + name + " " + help + "\n")
+	pw.w.WriteString("# TYPE " + metricsNamespace + name + " " + typ + "\n")
+}
+
+func (pw promWriter) sample(name, labels, value string) {
+	pw.w.WriteString(metricsNamespace + name)
+	if labels != _EMPTY_ {
+		pw.w.WriteString("{" + labels + "}")
+	}
+	pw.w.WriteString(" " + value + "\n")
+}
+
+func (pw promWriter) counter(name, help string, v uint64) {
+	pw.header(name, "counter", help)
+	pw.sample(name, _EMPTY_, strconv.FormatUint(v, 10))
+}
+
+func (pw promWriter) histogram(name, labels string, h HistogramSnapshot) {
+	sep := _EMPTY_
+	if labels != _EMPTY_ {
+		sep = ","
+	}
+	var cum uint64
+	for i, b := range h.Buckets {
+		cum += h.Counts[i]
+		pw.sample(name+"_bucket", labels+sep+`le="`+formatSeconds(b)+`"`, strconv.FormatUint(cum, 10))
+	}
+	pw.sample(name+"_bucket", labels+sep+`le="+Inf"`, strconv.FormatUint(h.Count, 10))
+	pw.sample(name+"_sum", labels, formatSeconds(h.Sum))
+	pw.sample(name+"_count", labels, strconv.FormatUint(h.Count, 10))
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(name, value string) string {
+	return name + `="` + promLabelEscaper.Replace(value) + `"`
+}
+
+func (m Metrics) writePrometheus(w *bufio.Writer) {
+	pw := promWriter{w}
+	pw.counter("in_msgs_total", "Messages received by the connection.", m.InMsgs)
+	pw.counter("in_bytes_total", "Bytes received by the connection.", m.InBytes)
+	pw.counter("out_msgs_total", "Messages sent by the connection.", m.OutMsgs)
+	pw.counter("out_bytes_total", "Bytes sent by the connection.", m.OutBytes)
+	pw.counter("reconnects_total", "Number of reconnections.", m.Reconnects)
+
+	pw.header("subscriptions", "gauge", "Number of active subscriptions.")
+	pw.sample("subscriptions", _EMPTY_, strconv.Itoa(len(m.Subscriptions)))
+	if len(m.Subscriptions) > 0 {
+		for _, f := range []struct {
+			name, help string
+			value      func(s *SubscriptionMetrics) string
+		}{
+			{"subscription_delivered_total", "Messages delivered to the subscription.",
+				func(s *SubscriptionMetrics) string { return strconv.FormatUint(s.Delivered, 10) }},
+			{"subscription_dropped_total", "Messages dropped by the subscription.",
+				func(s *SubscriptionMetrics) string { return strconv.Itoa(s.Dropped) }},
+			{"subscription_pending_msgs", "Messages pending delivery to the subscription.",
+				func(s *SubscriptionMetrics) string { return strconv.Itoa(s.PendingMsgs) }},
+			{"subscription_pending_bytes", "Bytes pending delivery to the subscription.",
+				func(s *SubscriptionMetrics) string { return strconv.Itoa(s.PendingBytes) }},
+		} {
+			typ := "gauge"
+			if strings.HasSuffix(f.name, "_total") {
+				typ = "counter"
+			}
+			pw.header(f.name, typ, f.help)
+			for i := range m.Subscriptions {
+				s := &m.Subscriptions[i]
+				pw.sample(f.name, promLabel("subject", s.Subject)+","+promLabel("queue", s.Queue), f.value(s))
+			}
+		}
+	}
+
+	// The remaining metrics are only available when enabled.
+	if m.Subjects == nil {
+		return
+	}
+	subjects := make([]string, 0, len(m.Subjects))
+	for s := range m.Subjects {
+		subjects = append(subjects, s)
+	}
+	sort.Strings(subjects)
+	for _, f := range []struct {
+		name, help string
+		value      func(s SubjectMetrics) uint64
+	}{
+		{"subject_out_msgs_total", "Messages published per subject prefix.",
+			func(s SubjectMetrics) uint64 { return s.OutMsgs }},
+		{"subject_out_bytes_total", "Bytes published per subject prefix.",
+			func(s SubjectMetrics) uint64 { return s.OutBytes }},
+		{"subject_in_msgs_total", "Messages received per subject prefix.",
+			func(s SubjectMetrics) uint64 { return s.InMsgs }},
+		{"subject_in_bytes_total", "Bytes received per subject prefix.",
+			func(s SubjectMetrics) uint64 { return s.InBytes }},
+	} {
+		pw.header(f.name, "counter", f.help)
+		for _, s := range subjects {
+			pw.sample(f.name, promLabel("subject", s), strconv.FormatUint(f.value(m.Subjects[s]), 10))
+		}
+	}
+
+	pw.header("request_duration_seconds", "histogram", "Latency of successful requests.")
+	pw.histogram("request_duration_seconds", _EMPTY_, m.RequestLatency)
+	pw.counter("request_errors_total", "Requests that failed, including timeouts.", m.RequestErrors)
+
+	pw.header("rtt_seconds", "histogram", "Round trip time to the server measured by pings.")
+	pw.histogram("rtt_seconds", _EMPTY_, m.RTT)
+	if n := len(m.RTTHistory); n > 0 {
+		pw.header("last_rtt_seconds", "gauge", "Last round trip time to the server.")
+		pw.sample("last_rtt_seconds", _EMPTY_, formatSeconds(m.RTTHistory[n-1]))
+	}
+
+	pw.header("reconnect_duration_seconds", "histogram", "Time taken to reconnect after a disconnect.")
+	pw.histogram("reconnect_duration_seconds", _EMPTY_, m.ReconnectDuration)
+
+	pw.counter("slow_consumer_events_total", "Number of times a subscription became a slow consumer.", m.SlowConsumers)
+
+	pw.header("js_ack_duration_seconds", "histogram", "Latency of JetStream acknowledgements.")
+	pw.histogram("js_ack_duration_seconds", promLabel("type", "publish"), m.JSPublishAckLatency)
+	pw.histogram("js_ack_duration_seconds", promLabel("type", "consumer"), m.JSAckLatency)
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go/subject"
+)
+
+var ErrMuxHandlerExists = errors.New("nats: handler already registered for subject")
+
+// Mux routes the messages received on a single, usually wildcard,
+// subscription to the handlers registered on more specific subjects.
+// When several handlers match a message's subject, the most specific one
+// is invoked: subjects are compared token by token, from left to right,
+// and at the first token that differs, a literal token is more specific
+// than '*', which is more specific than '>'.
+// Messages that match no handler are passed to the fallback handler, if
+// set, and dropped otherwise.
+type Mux struct {
+	sub     *Subscription
+	subject string
+	routes  *subject.Sublist
+
+	mu       sync.RWMutex
+	handlers map[string]*muxRoute
+	fallback MsgHandler
+}
+
+type muxRoute struct {
+	subject string
+	rank    string
+	cb      MsgHandler
+}
+
+// Ranks of the tokens, lower is more specific.
+const (
+	muxLiteral byte = iota
+	muxPwc
+	muxFwc
+)
+
+// NewMux creates a subscription on the given subject whose messages are
+// routed to the handlers registered with Mux.Handle.
+func (nc *Conn) NewMux(subj string) (*Mux, error) {
+	return nc.newMux(subj, _EMPTY_)
+}
+
+// NewQueueMux is like NewMux, but the subscription is part of
+// the given queue group.
+func (nc *Conn) NewQueueMux(subj, queue string) (*Mux, error) {
+	return nc.newMux(subj, queue)
+}
+
+func (nc *Conn) newMux(subj, queue string) (*Mux, error) {
+	mux := &Mux{subject: subj, routes: subject.NewSublist(), handlers: make(map[string]*muxRoute)}
+	sub, err := nc.subscribe(subj, queue, mux.dispatch, nil, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	mux.sub = sub
+	return mux, nil
+}
+
+// Handle registers the handler for the given subject, which may contain
+// wildcards and must be covered by the Mux's subscription subject,
+// otherwise ErrBadSubject is returned.
+func (mux *Mux) Handle(subj string, cb MsgHandler) error {
+	if cb == nil {
+		return ErrInvalidArg
+	}
+	if !subject.IsValidFilter(subj) || !subject.IsSubsetOf(subj, mux.subject) {
+		return ErrBadSubject
+	}
+	tokens := subject.Tokens(subj)
+	rank := make([]byte, len(tokens))
+	for i, t := range tokens {
+		switch t {
+		case subject.Pwc:
+			rank[i] = muxPwc
+		case subject.Fwc:
+			rank[i] = muxFwc
+		}
+	}
+	route := &muxRoute{subject: subj, rank: string(rank), cb: cb}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if _, ok := mux.handlers[subj]; ok {
+		return ErrMuxHandlerExists
+	}
+	if err := mux.routes.Insert(subj, route); err != nil {
+		return ErrBadSubject
+	}
+	mux.handlers[subj] = route
+	return nil
+}
+
+// Remove removes the handler registered for the given subject,
+// and reports whether there was one.
+func (mux *Mux) Remove(subj string) bool {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	route, ok := mux.handlers[subj]
+	if !ok {
+		return false
+	}
+	delete(mux.handlers, subj)
+	mux.routes.Remove(subj, route)
+	return true
+}
+
+// SetFallback sets the handler for the messages that match no
+// registered handler.
+func (mux *Mux) SetFallback(cb MsgHandler) {
+	mux.mu.Lock()
+	mux.fallback = cb
+	mux.mu.Unlock()
+}
+
+// Subscription returns the Mux's subscription, for instance
+// to set its pending limits or concurrency.
+func (mux *Mux) Subscription() *Subscription {
+	return mux.sub
+}
+
+// Unsubscribe removes the Mux's subscription.
+func (mux *Mux) Unsubscribe() error {
+	return mux.sub.Unsubscribe()
+}
+
+// Drain removes the Mux's subscription after the pending messages
+// have been processed. The subscription is also drained by Conn.Drain.
+func (mux *Mux) Drain() error {
+	return mux.sub.Drain()
+}
+
+func (mux *Mux) dispatch(m *Msg) {
+	// Lower ranks are more specific, and two distinct subjects
+	// matching the same message can not have the same rank.
+	var cb MsgHandler
+	var rank string
+	for _, v := range mux.routes.Match(m.Subject) {
+		if r := v.(*muxRoute); cb == nil || r.rank < rank {
+			cb, rank = r.cb, r.rank
+		}
+	}
+	if cb == nil {
+		mux.mu.RLock()
+		cb = mux.fallback
+		mux.mu.RUnlock()
+	}
+	if cb != nil {
+		cb(m)
+	}
+}
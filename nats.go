@@ -17,8 +17,9 @@ package nats
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -227,6 +228,13 @@ type ConnHandler func(*Conn)
 // disconnected connection with the error (if any).
 type ConnErrHandler func(*Conn, error)
 
+// WebSocketRequestFunc is used to modify the websocket upgrade request.
+type WebSocketRequestFunc func(*http.Request) error
+
+// WebSocketResponseFunc is used to inspect the response to the
+// websocket upgrade request.
+type WebSocketResponseFunc func(*http.Response) error
+
 // ErrHandler is used to process asynchronous errors encountered
 // while processing inbound messages.
 type ErrHandler func(*Conn, *Subscription, error)
@@ -313,6 +321,17 @@ type Options struct {
 	// transports.
 	TLSConfig *tls.Config
 
+	// ClientCertFile and ClientKeyFile are the files of the client
+	// certificate, reloaded before a TLS handshake if they changed.
+	// They take precedence over the certificates of TLSConfig.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// RootCAFiles are the files of the root CAs used to verify the
+	// server certificate, reloaded before a TLS handshake if they changed.
+	// They take precedence over the root CAs of TLSConfig.
+	RootCAFiles []string
+
 	// AllowReconnect enables reconnection logic to be used when we
 	// encounter a disconnect from the current server.
 	AllowReconnect bool
@@ -394,6 +413,14 @@ type Options struct {
 	// Once this has been exhausted publish operations will return an error.
 	ReconnectBufSize int
 
+	// ReconnectJournal, if set, spills the reconnect buffer to disk
+	// instead of failing publish operations once it has been exhausted.
+	ReconnectJournal *ReconnectJournal
+
+	// ProtocolRecorder, if set, records the protocol exchanged
+	// with the servers.
+	ProtocolRecorder *ProtocolRecorder
+
 	// SubChanLen is the size of the buffered channel used between the socket
 	// Go routine and the message delivery for SyncSubscriptions.
 	// NOTE: This does not affect AsyncSubscriptions which are
@@ -432,6 +459,14 @@ type Options struct {
 	// a *net.Dialer).
 	CustomDialer CustomDialer
 
+	// Proxy returns the proxy to connect to a server through, if any.
+	// The dialer is then used to connect to the proxy.
+	Proxy ProxyFunc
+
+	// InProcessServer provides the connections to a server running in the
+	// same process. When set, the server URLs are not dialed.
+	InProcessServer InProcessConnProvider
+
 	// UseOldRequestStyle forces the old method of Requests that utilize
 	// a new Inbox and a new Subscription for each request.
 	UseOldRequestStyle bool
@@ -447,6 +482,16 @@ type Options struct {
 	// often used in deployments when upgrading NATS Servers.
 	LameDuckModeHandler ConnHandler
 
+	// LameDuckModeReconnect makes the connection reconnect to another
+	// server of the pool when the server notifies that it entered lame
+	// duck mode, after a random delay up to LameDuckModeReconnectJitter.
+	LameDuckModeReconnect bool
+
+	// LameDuckModeReconnectJitter is the maximum delay before reconnecting
+	// when LameDuckModeReconnect is set, so that the clients of a server
+	// do not all move at once.
+	LameDuckModeReconnectJitter time.Duration
+
 	// RetryOnFailedConnect sets the connection in reconnecting state right
 	// away if it can't connect to a server in the initial set. The
 	// MaxReconnect and ReconnectWait options are used for this process,
@@ -460,8 +505,81 @@ type Options struct {
 	// supports compression. If the server does too, then data will be compressed.
 	Compression bool
 
+	// WebSocketHeaders are added to the websocket upgrade request, for
+	// instance to set the Origin, Authorization or Cookie headers.
+	// The headers required by the websocket protocol can not be replaced.
+	WebSocketHeaders http.Header
+
+	// WebSocketRequestCB is invoked before each websocket upgrade request
+	// is sent, after WebSocketHeaders were added. It may modify the
+	// request, for instance to add a fresh token or cookies.
+	WebSocketRequestCB WebSocketRequestFunc
+
+	// WebSocketResponseCB is invoked with the response to each websocket
+	// upgrade request, before it is validated. Returning an error fails
+	// the connection attempt.
+	WebSocketResponseCB WebSocketResponseFunc
+
+	// WebSocketNoMasking asks the server to accept unmasked websocket
+	// frames. Frames are still masked if the server does not agree.
+	WebSocketNoMasking bool
+
+	// WebSocketMaxFrameSize is the maximum payload size of the websocket
+	// frames sent to the server, larger messages are split in several
+	// frames. Zero means no limit.
+	WebSocketMaxFrameSize int
+
+	// CompressionLevel is the deflate level used for websocket compression,
+	// from flate.HuffmanOnly to flate.BestCompression. Zero means
+	// flate.BestSpeed.
+	CompressionLevel int
+
+	// CompressionContextTakeover asks the server to let the client keep
+	// its compression context between messages, which improves the
+	// compression of similar messages at the cost of memory.
+	CompressionContextTakeover bool
+
 	// InboxPrefix allows the default _INBOX prefix to be customized
 	InboxPrefix string
+
+	// PublishInterceptors is a chain of interceptors invoked, in order,
+	// for every message published on this connection, including requests.
+	PublishInterceptors []PublishInterceptor
+
+	// DeliveryInterceptors is a chain of interceptors invoked, in order,
+	// for every message delivered to a subscription, except the internal
+	// subscriptions receiving the responses to requests.
+	DeliveryInterceptors []DeliveryInterceptor
+
+	// Logger, if set, receives structured log entries for connection
+	// lifecycle events and asynchronous errors. Asynchronous errors are
+	// then no longer written to os.Stderr when no AsyncErrorCB is set.
+	Logger Logger
+
+	// Metrics enables the collection of client metrics, see Conn.Metrics().
+	Metrics bool
+
+	// MetricsSubjectTokens is the number of subject tokens used to
+	// aggregate per-subject metrics. Defaults to DefaultMetricsSubjectTokens.
+	MetricsSubjectTokens int
+
+	// Tracer, if set, is used to propagate trace context through
+	// message headers, see Msg.Context().
+	Tracer Tracer
+
+	// RequestPolicy, if set, configures retries, hedging and circuit
+	// breaking for requests.
+	RequestPolicy *RequestPolicy
+
+	// ServerSelector, if set, selects the server to connect, or reconnect,
+	// to. It replaces the randomization of the server pool, and NoRandomize
+	// is then ignored.
+	ServerSelector ServerSelector
+
+	// DiscoveredServersFilter, if set, is invoked with the URL of servers
+	// discovered through the cluster, which are only added to the server
+	// pool if it returns true.
+	DiscoveredServersFilter func(url string) bool
 }
 
 const (
@@ -523,6 +641,7 @@ type Conn struct {
 	ar      bool // abort reconnect
 	rqch    chan struct{}
 	ws      bool // true if a websocket connection
+	wsExts  []string
 
 	// New style response handler
 	respSub       string               // The wildcard subject
@@ -531,8 +650,31 @@ type Conn struct {
 	respScanf     string               // The scanf template to extract mux token
 	respMux       *Subscription        // A single response subscription
 	respMap       map[string]chan *Msg // Request map for the response msg channels
+	respMany      map[string]struct{}  // Tokens of requests expecting many responses
 	respRand      *rand.Rand           // Used for generating suffix
 
+	// Publish interceptor chain, nil if no interceptor is configured.
+	pubChain PublishFunc
+
+	// Metrics collector, nil if metrics are not enabled.
+	metrics *connMetrics
+
+	// Request policy, nil if not configured.
+	reqPolicy *requestPolicy
+
+	// Server to reconnect to first, set by SwitchServer.
+	switchTo *srv
+
+	// Timer of the reconnect scheduled on lame duck mode.
+	ldmTimer *time.Timer
+
+	// TLS material loaded from ClientCertFile and RootCAFiles.
+	tlsFiles *tlsFiles
+
+	// Listeners registered with StatusChanged and Events.
+	statusListeners []*statusListener
+	eventListeners  []*eventListener
+
 	// Msg filters for testing.
 	// Protected by subsMu
 	filters map[string]msgFilter
@@ -551,6 +693,7 @@ type natsWriter struct {
 	limit   int
 	pending *bytes.Buffer
 	plimit  int
+	journal *reconnectJournal
 }
 
 // Subscription represents interest in a given subject.
@@ -595,6 +738,16 @@ type Subscription struct {
 	pMsgsLimit  int
 	pBytesLimit int
 	dropped     int
+
+	// Concurrent delivery, see SetConcurrency.
+	dispatcher *subDispatcher
+
+	// Set to 1 if messages come from a pool, see SetMsgPooling.
+	pooled uint32
+
+	// Delivery interceptors of synchronous and channel based subscriptions,
+	// invoked before the message is queued. Set at creation only.
+	interceptors []DeliveryInterceptor
 }
 
 // Msg represents a message delivered by NATS. This structure is used
@@ -625,6 +778,9 @@ type Msg struct {
 	next    *Msg
 	barrier *barrierInfo
 	ackd    uint32
+	ctx     context.Context
+	pooled  bool
+	buf     []byte
 }
 
 func (m *Msg) headerBytes() ([]byte, error) {
@@ -675,6 +831,11 @@ type srv struct {
 	lastErr    error
 	isImplicit bool
 	tlsName    string
+	failed     bool
+	rtt        time.Duration
+	name       string
+	cluster    string
+	tags       []string
 }
 
 // The INFO block received from the server.
@@ -696,6 +857,7 @@ type serverInfo struct {
 	Cluster      string   `json:"cluster,omitempty"`
 	ConnectURLs  []string `json:"connect_urls,omitempty"`
 	LameDuckMode bool     `json:"ldm,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
 }
 
 const (
@@ -780,16 +942,9 @@ func Secure(tls ...*tls.Config) Option {
 // If Secure is not already set this will set it as well.
 func RootCAs(file ...string) Option {
 	return func(o *Options) error {
-		pool := x509.NewCertPool()
-		for _, f := range file {
-			rootPEM, err := ioutil.ReadFile(f)
-			if err != nil || rootPEM == nil {
-				return fmt.Errorf("nats: error loading or parsing rootCA file: %v", err)
-			}
-			ok := pool.AppendCertsFromPEM(rootPEM)
-			if !ok {
-				return fmt.Errorf("nats: failed to parse root certificate from %q", f)
-			}
+		pool, err := loadRootCAs(file)
+		if err != nil {
+			return err
 		}
 		if o.TLSConfig == nil {
 			o.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
@@ -804,18 +959,14 @@ func RootCAs(file ...string) Option {
 // If Secure is not already set this will set it as well.
 func ClientCert(certFile, keyFile string) Option {
 	return func(o *Options) error {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		cert, err := loadClientCert(certFile, keyFile)
 		if err != nil {
-			return fmt.Errorf("nats: error loading client certificate: %v", err)
-		}
-		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
-		if err != nil {
-			return fmt.Errorf("nats: error parsing client certificate: %v", err)
+			return err
 		}
 		if o.TLSConfig == nil {
 			o.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
 		}
-		o.TLSConfig.Certificates = []tls.Certificate{cert}
+		o.TLSConfig.Certificates = []tls.Certificate{*cert}
 		o.Secure = true
 		return nil
 	}
@@ -1139,6 +1290,76 @@ func Compression(enabled bool) Option {
 	}
 }
 
+// WebSocketHeaders is an Option to add headers to the websocket
+// upgrade request.
+func WebSocketHeaders(headers http.Header) Option {
+	return func(o *Options) error {
+		o.WebSocketHeaders = headers
+		return nil
+	}
+}
+
+// WebSocketRequestHandler is an Option to set the callback invoked
+// before each websocket upgrade request is sent.
+func WebSocketRequestHandler(cb WebSocketRequestFunc) Option {
+	return func(o *Options) error {
+		o.WebSocketRequestCB = cb
+		return nil
+	}
+}
+
+// WebSocketResponseHandler is an Option to set the callback invoked
+// with the response to each websocket upgrade request.
+func WebSocketResponseHandler(cb WebSocketResponseFunc) Option {
+	return func(o *Options) error {
+		o.WebSocketResponseCB = cb
+		return nil
+	}
+}
+
+// WebSocketNoMasking is an Option to ask the server to accept unmasked
+// websocket frames. Use only when the connection can not be tampered
+// with, for instance over TLS.
+func WebSocketNoMasking() Option {
+	return func(o *Options) error {
+		o.WebSocketNoMasking = true
+		return nil
+	}
+}
+
+// WebSocketMaxFrameSize is an Option to set the maximum payload size
+// of the websocket frames sent to the server.
+func WebSocketMaxFrameSize(size int) Option {
+	return func(o *Options) error {
+		if size < 0 {
+			return ErrInvalidArg
+		}
+		o.WebSocketMaxFrameSize = size
+		return nil
+	}
+}
+
+// CompressionLevel is an Option to set the deflate level used for
+// websocket compression, see compress/flate.
+func CompressionLevel(level int) Option {
+	return func(o *Options) error {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return ErrInvalidArg
+		}
+		o.CompressionLevel = level
+		return nil
+	}
+}
+
+// CompressionContextTakeover is an Option to keep the compression
+// context between the messages sent to the server, if it agrees.
+func CompressionContextTakeover() Option {
+	return func(o *Options) error {
+		o.CompressionContextTakeover = true
+		return nil
+	}
+}
+
 // CustomInboxPrefix configures the request + reply inbox prefix
 func CustomInboxPrefix(p string) Option {
 	return func(o *Options) error {
@@ -1243,6 +1464,12 @@ func (o Options) Connect() (*Conn, error) {
 	if nc.Opts.Timeout == 0 {
 		nc.Opts.Timeout = DefaultTimeout
 	}
+	if nc.Opts.Metrics {
+		nc.metrics = newConnMetrics(nc.Opts.MetricsSubjectTokens)
+	}
+	if nc.Opts.RequestPolicy != nil {
+		nc.reqPolicy = newRequestPolicy(*nc.Opts.RequestPolicy)
+	}
 
 	// Check first for user jwt callback being defined and nkey.
 	if nc.Opts.UserJWT != nil && nc.Opts.Nkey != "" {
@@ -1274,6 +1501,16 @@ func (o Options) Connect() (*Conn, error) {
 		nc.Opts.AsyncErrorCB = defaultErrHandler
 	}
 
+	// Build the publish interceptor chain, if any. The trace context
+	// is injected first so that other interceptors can see it.
+	pis := nc.Opts.PublishInterceptors
+	if nc.Opts.Tracer != nil {
+		pis = append([]PublishInterceptor{nc.injectTraceContext}, pis...)
+	}
+	if len(pis) > 0 {
+		nc.pubChain = chainPublishInterceptors(pis, nc.publishInterceptedMsg)
+	}
+
 	// Create reader/writer
 	nc.newReaderWriter()
 
@@ -1289,21 +1526,20 @@ func (o Options) Connect() (*Conn, error) {
 
 func defaultErrHandler(nc *Conn, sub *Subscription, err error) {
 	var cid uint64
+	var logger Logger
 	if nc != nil {
 		nc.mu.RLock()
 		cid = nc.info.CID
+		logger = nc.Opts.Logger
 		nc.mu.RUnlock()
 	}
+	// The error was already reported through the logger.
+	if logger != nil {
+		return
+	}
 	var errStr string
 	if sub != nil {
-		var subject string
-		sub.mu.Lock()
-		if sub.jsi != nil {
-			subject = sub.jsi.psubj
-		} else {
-			subject = sub.Subject
-		}
-		sub.mu.Unlock()
+		subject := sub.errSubject()
 		errStr = fmt.Sprintf("%s on connection [%d] for subscription on %q\n", err.Error(), cid, subject)
 	} else {
 		errStr = fmt.Sprintf("%s on connection [%d]\n", err.Error(), cid)
@@ -1311,6 +1547,17 @@ func defaultErrHandler(nc *Conn, sub *Subscription, err error) {
 	os.Stderr.WriteString(errStr)
 }
 
+// errSubject returns the subject reported with the asynchronous
+// errors of the subscription.
+func (sub *Subscription) errSubject() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.jsi != nil {
+		return sub.jsi.psubj
+	}
+	return sub.Subject
+}
+
 const (
 	_CRLF_   = "\r\n"
 	_EMPTY_  = ""
@@ -1370,6 +1617,24 @@ func (nc *Conn) selectNextServer() (*srv, error) {
 		nc.current = nil
 		return nil, ErrNoServers
 	}
+	if sw := nc.switchTo; sw != nil {
+		// A server was requested with SwitchServer, try it first.
+		nc.switchTo = nil
+		for j, ps := range nc.srvPool {
+			if ps == sw {
+				copy(nc.srvPool[1:j+1], nc.srvPool[:j])
+				nc.srvPool[0] = sw
+				break
+			}
+		}
+	} else if nc.Opts.ServerSelector != nil {
+		// Do not offer the server we just left, unless it is the only one.
+		end := len(nc.srvPool)
+		if end > 1 && nc.srvPool[end-1] == s {
+			end--
+		}
+		nc.selectServer(0, end)
+	}
 	nc.current = nc.srvPool[0]
 	return nc.srvPool[0], nil
 }
@@ -1409,7 +1674,7 @@ func (nc *Conn) setupServerPool() error {
 	}
 
 	// Randomize if allowed to
-	if !nc.Opts.NoRandomize {
+	if !nc.Opts.NoRandomize && nc.Opts.ServerSelector == nil {
 		nc.shufflePool(0)
 	}
 
@@ -1432,6 +1697,13 @@ func (nc *Conn) setupServerPool() error {
 		}
 	}
 
+	// Let the selector order the pool, if set.
+	if nc.Opts.ServerSelector != nil {
+		for i := 0; i < len(nc.srvPool)-1; i++ {
+			nc.selectServer(i, len(nc.srvPool))
+		}
+	}
+
 	// Check for Scheme hint to move to TLS mode.
 	for _, srv := range nc.srvPool {
 		if srv.url.Scheme == tlsScheme {
@@ -1461,8 +1733,9 @@ func (nc *Conn) connScheme() string {
 }
 
 // Return true iff u.Hostname() is an IP address.
+// Unix socket URLs have no host name.
 func hostIsIP(u *url.URL) bool {
-	return net.ParseIP(u.Hostname()) != nil
+	return !isUnixScheme(u) && net.ParseIP(u.Hostname()) != nil
 }
 
 // addURLToPool adds an entry to the server pool
@@ -1479,7 +1752,7 @@ func (nc *Conn) addURLToPool(sURL string, implicit, saveTLSName bool) error {
 		if err != nil {
 			return err
 		}
-		if u.Port() != "" {
+		if u.Port() != "" || isUnixScheme(u) {
 			break
 		}
 		// In case given URL is of the form "localhost:", just add
@@ -1497,6 +1770,10 @@ func (nc *Conn) addURLToPool(sURL string, implicit, saveTLSName bool) error {
 		}
 	}
 
+	if isUnixScheme(u) && (unixSocketPath(u) == _EMPTY_ || u.User != nil) {
+		return ErrInvalidUnixURL
+	}
+
 	isWS := isWebsocketScheme(u)
 	// We don't support mix and match of websocket and non websocket URLs.
 	// If this is the first URL, then we accept and switch the global state
@@ -1525,7 +1802,7 @@ func (nc *Conn) addURLToPool(sURL string, implicit, saveTLSName bool) error {
 
 	s := &srv{url: u, isImplicit: implicit, tlsName: tlsName}
 	nc.srvPool = append(nc.srvPool, s)
-	nc.urls[u.Host] = struct{}{}
+	nc.urls[serverAddr(u)] = struct{}{}
 	return nil
 }
 
@@ -1553,6 +1830,9 @@ func (nc *Conn) newReaderWriter() {
 		limit:  defaultBufSize,
 		plimit: nc.Opts.ReconnectBufSize,
 	}
+	if nc.Opts.ReconnectJournal != nil {
+		nc.bw.journal = nc.newReconnectJournal()
+	}
 }
 
 func (nc *Conn) bindToNewConn() {
@@ -1560,6 +1840,11 @@ func (nc *Conn) bindToNewConn() {
 	bw.w, bw.bufs = nc.newWriter(), nil
 	br := nc.br
 	br.r, br.n, br.off = nc.conn, 0, -1
+	// Websocket connections are recorded after the handshake, which
+	// is done on the raw connection bound here.
+	if nc.Opts.InProcessServer != nil || !isWebsocketScheme(nc.current.url) {
+		nc.recordIO()
+	}
 }
 
 func (nc *Conn) newWriter() io.Writer {
@@ -1588,6 +1873,11 @@ func (w *natsWriter) appendBufs(bufs ...[]byte) error {
 	if w.pending == nil && len(w.bufs) >= w.limit {
 		return w.flush()
 	}
+	// Spill the pending buffer to the journal, if set, when full.
+	if w.pending != nil && w.journal != nil && w.pending.Len() >= w.plimit {
+		w.journal.spill(w.pending.Bytes())
+		w.pending.Reset()
+	}
 	return nil
 }
 
@@ -1617,7 +1907,11 @@ func (w *natsWriter) flush() error {
 
 func (w *natsWriter) buffered() int {
 	if w.pending != nil {
-		return w.pending.Len()
+		n := w.pending.Len()
+		if w.journal != nil {
+			n += int(w.journal.size)
+		}
+		return n
 	}
 	return len(w.bufs)
 }
@@ -1627,7 +1921,16 @@ func (w *natsWriter) switchToPending() {
 }
 
 func (w *natsWriter) flushPendingBuffer() error {
-	if w.pending == nil || w.pending.Len() == 0 {
+	if w.pending == nil {
+		return nil
+	}
+	// Journaled data is older than the pending buffer's.
+	if w.journal != nil {
+		if err := w.journal.replay(w.w); err != nil {
+			return err
+		}
+	}
+	if w.pending.Len() == 0 {
 		return nil
 	}
 	_, err := w.w.Write(w.pending.Bytes())
@@ -1638,7 +1941,7 @@ func (w *natsWriter) flushPendingBuffer() error {
 }
 
 func (w *natsWriter) atLimitIfUsingPending() bool {
-	if w.pending == nil {
+	if w.pending == nil || w.journal != nil {
 		return false
 	}
 	return w.pending.Len() >= w.plimit
@@ -1696,11 +1999,35 @@ func (nc *Conn) createConn() (err error) {
 		return ErrNoServers
 	}
 
+	u := nc.current.url
+
+	// An in-process server replaces dialing.
+	if nc.Opts.InProcessServer != nil {
+		conn, err := nc.Opts.InProcessServer.InProcessConn()
+		if err != nil {
+			return fmt.Errorf("nats: error getting in-process connection: %v", err)
+		}
+		nc.conn = conn
+		nc.recordConnect()
+		nc.bindToNewConn()
+		return nil
+	}
+
 	// We will auto-expand host names if they resolve to multiple IPs
 	hosts := []string{}
-	u := nc.current.url
 
-	if net.ParseIP(u.Hostname()) == nil {
+	// Host names are resolved by the proxy, if any.
+	// Unix sockets are local, and so never proxied.
+	var proxy *url.URL
+	if nc.Opts.Proxy != nil && !isUnixScheme(u) {
+		if proxy, err = nc.Opts.Proxy(u); err != nil {
+			return err
+		}
+	}
+
+	if isUnixScheme(u) {
+		hosts = append(hosts, unixSocketPath(u))
+	} else if proxy == nil && net.ParseIP(u.Hostname()) == nil {
 		addrs, _ := net.LookupHost(u.Hostname())
 		for _, addr := range addrs {
 			hosts = append(hosts, net.JoinHostPort(addr, u.Port()))
@@ -1728,7 +2055,13 @@ func (nc *Conn) createConn() (err error) {
 		})
 	}
 	for _, host := range hosts {
-		nc.conn, err = dialer.Dial("tcp", host)
+		if proxy != nil {
+			nc.conn, err = dialProxy(dialer, proxy, host, nc.Opts.Timeout)
+		} else if isUnixScheme(u) {
+			nc.conn, err = dialer.Dial("unix", host)
+		} else {
+			nc.conn, err = dialer.Dial("tcp", host)
+		}
 		if err == nil {
 			break
 		}
@@ -1736,6 +2069,7 @@ func (nc *Conn) createConn() (err error) {
 	if err != nil {
 		return err
 	}
+	nc.recordConnect()
 
 	// If scheme starts with "ws" then branch out to websocket code.
 	if isWebsocketScheme(u) {
@@ -1756,8 +2090,9 @@ func (nc *Conn) makeTLSConn() error {
 	} else {
 		tlsCopy = &tls.Config{}
 	}
-	// If its blank we will override it with the current host
-	if tlsCopy.ServerName == _EMPTY_ {
+	// If its blank we will override it with the current host.
+	// Unix socket URLs have no host, the name must then be configured.
+	if tlsCopy.ServerName == _EMPTY_ && !isUnixScheme(nc.current.url) {
 		if nc.current.tlsName != _EMPTY_ {
 			tlsCopy.ServerName = nc.current.tlsName
 		} else {
@@ -1765,6 +2100,9 @@ func (nc *Conn) makeTLSConn() error {
 			tlsCopy.ServerName = h
 		}
 	}
+	if err := nc.applyTLSFiles(tlsCopy); err != nil {
+		return err
+	}
 	nc.conn = tls.Client(nc.conn, tlsCopy)
 	conn := nc.conn.(*tls.Conn)
 	if err := conn.Handshake(); err != nil {
@@ -1912,6 +2250,9 @@ func (nc *Conn) ConnectedClusterName() string {
 func (nc *Conn) setup() {
 	nc.subs = make(map[int64]*Subscription)
 	nc.pongs = make([]chan struct{}, 0, 8)
+	if nc.metrics != nil {
+		nc.metrics.clearPings()
+	}
 
 	nc.fch = make(chan struct{}, flushChanSize)
 	nc.rqch = make(chan struct{})
@@ -1936,6 +2277,7 @@ func (nc *Conn) processConnectInit() error {
 	if err != nil {
 		return err
 	}
+	nc.current.name, nc.current.cluster, nc.current.tags = nc.info.Name, nc.info.Cluster, nc.info.Tags
 
 	// Send the CONNECT protocol along with the initial PING protocol.
 	// Wait for the PONG response (or any error that we get from the server).
@@ -1989,8 +2331,12 @@ func (nc *Conn) connect() error {
 				nc.current.didConnect = true
 				nc.current.reconnects = 0
 				nc.current.lastErr = nil
+				nc.current.failed = false
+				nc.log(LogLevelInfo, "connected", "server", nc.currentURL(), "server_id", nc.info.ID, "cid", nc.info.CID)
 				break
 			} else {
+				nc.log(LogLevelWarn, "connect failed", "server", nc.currentURL(), "error", err)
+				nc.current.failed = true
 				nc.mu.Unlock()
 				nc.close(DISCONNECTED, false, err)
 				nc.mu.Lock()
@@ -1999,6 +2345,8 @@ func (nc *Conn) connect() error {
 				// to try before starting doReconnect().
 			}
 		} else {
+			nc.log(LogLevelWarn, "connect failed", "server", nc.currentURL(), "error", err)
+			nc.current.failed = true
 			// Cancel out default connection refused, will trigger the
 			// No servers error conditional
 			if strings.Contains(err.Error(), "connection refused") {
@@ -2014,6 +2362,7 @@ func (nc *Conn) connect() error {
 	if err == nil {
 		nc.initc = false
 	} else if nc.Opts.RetryOnFailedConnect {
+		nc.log(LogLevelInfo, "initial connect failed, retrying in background", "error", err)
 		nc.setup()
 		nc.status = RECONNECTING
 		nc.bw.switchToPending()
@@ -2185,6 +2534,7 @@ func (nc *Conn) sendConnect() error {
 	}
 
 	// Write the protocol and PING directly to the underlying writer.
+	start := time.Now()
 	if err := nc.bw.writeDirect(cProto, pingProto); err != nil {
 		return err
 	}
@@ -2238,6 +2588,7 @@ func (nc *Conn) sendConnect() error {
 
 	// This is where we are truly connected.
 	nc.status = CONNECTED
+	nc.current.rtt = time.Since(start)
 
 	return nil
 }
@@ -2281,6 +2632,29 @@ func (nc *Conn) flushReconnectPendingItems() error {
 	return nc.bw.flushPendingBuffer()
 }
 
+// replayReconnectJournal replays the reconnect journal, if any, one
+// segment at a time, releasing the lock in between so that other calls
+// are not blocked. Data published meanwhile is still buffered, and
+// replayed after. Lock is held on entry.
+func (nc *Conn) replayReconnectJournal() error {
+	j := nc.bw.journal
+	if j == nil {
+		return nil
+	}
+	for {
+		done, err := j.replaySegment(nc.bw.w)
+		if done || err != nil {
+			return err
+		}
+		nc.mu.Unlock()
+		runtime.Gosched()
+		nc.mu.Lock()
+		if nc.isClosed() {
+			return ErrConnectionClosed
+		}
+	}
+}
+
 // Stops the ping timer if set.
 // Connection lock is held on entry.
 func (nc *Conn) stopPingTimer() {
@@ -2309,13 +2683,18 @@ func (nc *Conn) doReconnect(err error) {
 	// Perform appropriate callback if needed for a disconnect.
 	// DisconnectedErrCB has priority over deprecated DisconnectedCB
 	if !nc.initc {
+		nc.log(LogLevelWarn, "disconnected", "server", nc.currentURL(), "error", err)
 		if nc.Opts.DisconnectedErrCB != nil {
 			nc.ach.push(func() { nc.Opts.DisconnectedErrCB(nc, err) })
 		} else if nc.Opts.DisconnectedCB != nil {
 			nc.ach.push(func() { nc.Opts.DisconnectedCB(nc) })
 		}
+		nc.emitEvent(DisconnectedEvent, true, err)
 	}
 
+	// Used to measure how long it takes to reconnect.
+	start := time.Now()
+
 	// This is used to wait on go routines exit if we start them in the loop
 	// but an error occurs after that.
 	waitForGoRoutines := false
@@ -2391,6 +2770,7 @@ func (nc *Conn) doReconnect(err error) {
 
 		// Mark that we tried a reconnect
 		cur.reconnects++
+		nc.log(LogLevelDebug, "reconnect attempt", "server", cur.url.String(), "attempt", cur.reconnects)
 
 		// Try to create a new connection
 		err = nc.createConn()
@@ -2398,6 +2778,8 @@ func (nc *Conn) doReconnect(err error) {
 		// Not yet connected, retry...
 		// Continue to hold the lock
 		if err != nil {
+			nc.log(LogLevelDebug, "reconnect attempt failed", "server", cur.url.String(), "error", err)
+			cur.failed = true
 			nc.err = nil
 			continue
 		}
@@ -2407,6 +2789,8 @@ func (nc *Conn) doReconnect(err error) {
 
 		// Process connect logic
 		if nc.err = nc.processConnectInit(); nc.err != nil {
+			nc.log(LogLevelWarn, "reconnect attempt failed", "server", cur.url.String(), "error", nc.err)
+			cur.failed = true
 			// Check if we should abort reconnect. If so, break out
 			// of the loop and connection will be closed.
 			if nc.ar {
@@ -2423,13 +2807,22 @@ func (nc *Conn) doReconnect(err error) {
 		// Clear out server stats for the server we connected to..
 		cur.didConnect = true
 		cur.reconnects = 0
+		cur.failed = false
 
 		// Send existing subscription state
 		nc.resendSubscriptions()
 
 		// Now send off and clear pending buffer
-		nc.err = nc.flushReconnectPendingItems()
+		nc.err = nc.replayReconnectJournal()
+		if nc.isClosed() {
+			break
+		}
+		if nc.err == nil {
+			nc.err = nc.flushReconnectPendingItems()
+		}
 		if nc.err != nil {
+			nc.log(LogLevelWarn, "reconnect attempt failed", "server", cur.url.String(), "error", nc.err)
+			cur.failed = true
 			nc.status = RECONNECTING
 			// Stop the ping timer (if set)
 			nc.stopPingTimer()
@@ -2445,6 +2838,16 @@ func (nc *Conn) doReconnect(err error) {
 
 		// This is where we are truly connected.
 		nc.status = CONNECTED
+		nc.log(LogLevelInfo, "reconnected", "server", cur.url.String(), "server_id", nc.info.ID, "cid", nc.info.CID)
+		if nc.metrics != nil {
+			nc.metrics.reconnect.observe(time.Since(start))
+		}
+
+		if nc.initc {
+			nc.emitEvent(ConnectedEvent, true, nil)
+		} else {
+			nc.emitEvent(ReconnectedEvent, true, nil)
+		}
 
 		// If we are here with a retry on failed connect, indicate that the
 		// initial connect is now complete.
@@ -2468,6 +2871,9 @@ func (nc *Conn) doReconnect(err error) {
 	if nc.err == nil {
 		nc.err = ErrNoServers
 	}
+	if !nc.isClosed() {
+		nc.log(LogLevelError, "reconnect failed, closing connection", "error", nc.err)
+	}
 	nc.mu.Unlock()
 	nc.close(CLOSED, true, nil)
 }
@@ -2482,32 +2888,40 @@ func (nc *Conn) processOpErr(err error) {
 	}
 
 	if nc.Opts.AllowReconnect && nc.status == CONNECTED {
-		// Set our new status
-		nc.status = RECONNECTING
-		// Stop ping timer if set
-		nc.stopPingTimer()
-		if nc.conn != nil {
-			nc.conn.Close()
-			nc.conn = nil
-		}
-
-		// Create pending buffer before reconnecting.
-		nc.bw.switchToPending()
-
-		// Clear any queued pongs, e.g. pending flush calls.
-		nc.clearPendingFlushCalls()
-
-		go nc.doReconnect(err)
+		nc.startReconnect(err)
 		nc.mu.Unlock()
 		return
 	}
 
+	nc.log(LogLevelWarn, "disconnected", "server", nc.currentURL(), "error", err)
 	nc.status = DISCONNECTED
 	nc.err = err
 	nc.mu.Unlock()
 	nc.close(CLOSED, true, nil)
 }
 
+// startReconnect closes the connection to the current server and
+// starts the reconnect loop.
+// Lock should be held.
+func (nc *Conn) startReconnect(err error) {
+	// Set our new status
+	nc.status = RECONNECTING
+	// Stop ping timer if set
+	nc.stopPingTimer()
+	if nc.conn != nil {
+		nc.conn.Close()
+		nc.conn = nil
+	}
+
+	// Create pending buffer before reconnecting.
+	nc.bw.switchToPending()
+
+	// Clear any queued pongs, e.g. pending flush calls.
+	nc.clearPendingFlushCalls()
+
+	go nc.doReconnect(err)
+}
+
 // dispatch is responsible for calling any async callbacks
 func (ac *asyncCallbacksHandler) asyncCBDispatcher() {
 	for {
@@ -2621,6 +3035,9 @@ func (nc *Conn) waitForMsgs(s *Subscription) {
 	// Used to account for adjustments to sub.pBytes when we wrap back around.
 	msgLen := -1
 
+	// Dispatcher in use for concurrent delivery, if any.
+	var d *subDispatcher
+
 	for {
 		s.mu.Lock()
 		// Do accounting for last msg delivered here so we only lock once
@@ -2643,6 +3060,10 @@ func (nc *Conn) waitForMsgs(s *Subscription) {
 			}
 			if m.barrier != nil {
 				s.mu.Unlock()
+				// Messages dispatched before the barrier must be processed first.
+				if d != nil {
+					d.wait()
+				}
 				if atomic.AddInt64(&m.barrier.refs, -1) == 0 {
 					m.barrier.f()
 				}
@@ -2651,6 +3072,17 @@ func (nc *Conn) waitForMsgs(s *Subscription) {
 			msgLen = len(m.Data)
 		}
 		mcb := s.mcb
+		if s.dispatcher != d {
+			if d != nil {
+				s.mu.Unlock()
+				d.stop()
+				s.mu.Lock()
+			}
+			d = s.dispatcher
+			if d != nil {
+				d.start(s, mcb)
+			}
+		}
 		max = s.max
 		closed = s.closed
 		var fcReply string
@@ -2674,16 +3106,28 @@ func (nc *Conn) waitForMsgs(s *Subscription) {
 
 		// Deliver the message.
 		if m != nil && (max == 0 || delivered <= max) {
-			mcb(m)
+			if d != nil {
+				// Pending stats are updated by the worker.
+				d.dispatch(m)
+				msgLen = -1
+			} else {
+				mcb(m)
+			}
 		}
 		// If we have hit the max for delivered msgs, remove sub.
 		if max > 0 && delivered >= max {
+			if d != nil {
+				d.wait()
+			}
 			nc.mu.Lock()
 			nc.removeSub(s)
 			nc.mu.Unlock()
 			break
 		}
 	}
+	if d != nil {
+		d.stop()
+	}
 	// Check for barrier messages
 	s.mu.Lock()
 	for m := s.pHead; m != nil; m = s.pHead {
@@ -2752,13 +3196,23 @@ func (nc *Conn) processMsg(data []byte) {
 	// Copy them into string
 	subj := string(nc.ps.ma.subject)
 	reply := string(nc.ps.ma.reply)
+	pooled := atomic.LoadUint32(&sub.pooled) == 1
+
+	if nc.metrics != nil {
+		nc.metrics.delivered(subj, len(data))
+	}
 
 	// Doing message create outside of the sub's lock to reduce contention.
 	// It's possible that we end-up not using the message, but that's ok.
 
 	// FIXME(dlc): Need to copy, should/can do COW?
 	var msgPayload = data
-	if !nc.ps.msgCopied {
+	var pm *Msg
+	if pooled {
+		// The payload is copied into the buffer of the pooled message.
+		pm = newPooledMsg(data)
+		msgPayload = pm.buf
+	} else if !nc.ps.msgCopied {
 		msgPayload = make([]byte, len(data))
 		copy(msgPayload, data)
 	}
@@ -2778,15 +3232,17 @@ func (nc *Conn) processMsg(data []byte) {
 			// We will pass the message through but send async error.
 			nc.mu.Lock()
 			nc.err = ErrBadHeaderMsg
-			if nc.Opts.AsyncErrorCB != nil {
-				nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, sub, ErrBadHeaderMsg) })
-			}
+			nc.asyncError(sub, ErrBadHeaderMsg)
 			nc.mu.Unlock()
 		}
 	}
 
-	// FIXME(dlc): Should we recycle these containers?
-	m := &Msg{Header: h, Data: msgPayload, Subject: subj, Reply: reply, Sub: sub}
+	// Messages are recycled only with message pooling.
+	m := pm
+	if m == nil {
+		m = &Msg{}
+	}
+	m.Header, m.Data, m.Subject, m.Reply, m.Sub = h, msgPayload, subj, reply, sub
 
 	// Check for message filters.
 	if mf != nil {
@@ -2796,11 +3252,23 @@ func (nc *Conn) processMsg(data []byte) {
 		}
 	}
 
+	// Invoke the delivery interceptors of synchronous and channel based
+	// subscriptions, unless this is a JetStream control message.
+	if sub.interceptors != nil {
+		if ctrl, _ := isJSControlMessage(m); !ctrl {
+			if m = interceptMsg(sub.interceptors, m); m == nil {
+				// Dropped by an interceptor.
+				return
+			}
+		}
+	}
+
 	sub.mu.Lock()
 
 	// Check if closed.
 	if sub.closed {
 		sub.mu.Unlock()
+		m.Release()
 		return
 	}
 
@@ -2920,15 +3388,18 @@ slowConsumer:
 		sub.pBytes -= len(m.Data)
 	}
 	sub.mu.Unlock()
+	// The message is dropped, and can be reused.
+	m.Release()
 	if sc {
+		if nc.metrics != nil {
+			atomic.AddUint64(&nc.metrics.slowConsumers, 1)
+		}
 		// Now we need connection's lock and we may end-up in the situation
 		// that we were trying to avoid, except that in this case, the client
 		// is already experiencing client-side slow consumer situation.
 		nc.mu.Lock()
 		nc.err = ErrSlowConsumer
-		if nc.Opts.AsyncErrorCB != nil {
-			nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, sub, ErrSlowConsumer) })
-		}
+		nc.asyncError(sub, ErrSlowConsumer)
 		nc.mu.Unlock()
 	}
 }
@@ -2940,9 +3411,7 @@ func (nc *Conn) processPermissionsViolation(err string) {
 	// create error here so we can pass it as a closure to the async cb dispatcher.
 	e := errors.New("nats: " + err)
 	nc.err = e
-	if nc.Opts.AsyncErrorCB != nil {
-		nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, nil, e) })
-	}
+	nc.asyncError(nil, e)
 	nc.mu.Unlock()
 }
 
@@ -2953,8 +3422,8 @@ func (nc *Conn) processPermissionsViolation(err string) {
 // Connection lock is held on entry
 func (nc *Conn) processAuthError(err error) bool {
 	nc.err = err
-	if !nc.initc && nc.Opts.AsyncErrorCB != nil {
-		nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, nil, err) })
+	if !nc.initc {
+		nc.asyncError(nil, err)
 	}
 	// We should give up if we tried twice on this server and got the
 	// same error.
@@ -3020,6 +3489,9 @@ func (nc *Conn) processPong() {
 	if len(nc.pongs) > 0 {
 		ch = nc.pongs[0]
 		nc.pongs = append(nc.pongs[:0], nc.pongs[1:]...)
+		if nc.metrics != nil {
+			nc.metrics.pongReceived()
+		}
 	}
 	nc.pout = 0
 	nc.mu.Unlock()
@@ -3052,9 +3524,7 @@ func (nc *Conn) processInfo(info string) error {
 	// did not include themselves in the async INFO protocol.
 	// If empty, do not remove the implicit servers from the pool.
 	if len(nc.info.ConnectURLs) == 0 {
-		if !nc.initc && ncInfo.LameDuckMode && nc.Opts.LameDuckModeHandler != nil {
-			nc.ach.push(func() { nc.Opts.LameDuckModeHandler(nc) })
-		}
+		nc.processLameDuckMode()
 		return nil
 	}
 	// Note about pool randomization: when the pool was first created,
@@ -3074,7 +3544,7 @@ func (nc *Conn) processInfo(info string) error {
 	sp := nc.srvPool
 	for i := 0; i < len(sp); i++ {
 		srv := sp[i]
-		curl := srv.url.Host
+		curl := serverAddr(srv.url)
 		// Check if this URL is in the INFO protocol
 		_, inInfo := tmp[curl]
 		// Remove from the temp map so that at the end we are left with only
@@ -3099,26 +3569,49 @@ func (nc *Conn) processInfo(info string) error {
 	// If there are any left in the tmp map, these are new (or restarted) servers
 	// and need to be added to the pool.
 	for curl := range tmp {
+		sURL := fmt.Sprintf("%s://%s", nc.connScheme(), curl)
+		if nc.Opts.DiscoveredServersFilter != nil && !nc.Opts.DiscoveredServersFilter(sURL) {
+			continue
+		}
 		// Before adding, check if this is a new (as in never seen) URL.
 		// This is used to figure out if we invoke the DiscoveredServersCB
 		if _, present := nc.urls[curl]; !present {
 			hasNew = true
 		}
-		nc.addURLToPool(fmt.Sprintf("%s://%s", nc.connScheme(), curl), true, saveTLS)
+		nc.addURLToPool(sURL, true, saveTLS)
 	}
 	if hasNew {
 		// Randomize the pool if allowed but leave the first URL in place.
-		if !nc.Opts.NoRandomize {
+		if !nc.Opts.NoRandomize && nc.Opts.ServerSelector == nil {
 			nc.shufflePool(1)
 		}
-		if !nc.initc && nc.Opts.DiscoveredServersCB != nil {
-			nc.ach.push(func() { nc.Opts.DiscoveredServersCB(nc) })
+		if !nc.initc {
+			nc.log(LogLevelInfo, "discovered servers", "servers", strings.Join(nc.info.ConnectURLs, ","))
+			if nc.Opts.DiscoveredServersCB != nil {
+				nc.ach.push(func() { nc.Opts.DiscoveredServersCB(nc) })
+			}
+			nc.emitEvent(DiscoveredServersEvent, false, nil)
 		}
 	}
-	if !nc.initc && ncInfo.LameDuckMode && nc.Opts.LameDuckModeHandler != nil {
+	nc.processLameDuckMode()
+	return nil
+}
+
+// processLameDuckMode notifies that the server we are connected to
+// has entered lame duck mode, if indicated in the last received INFO.
+// Lock should be held.
+func (nc *Conn) processLameDuckMode() {
+	if nc.initc || !nc.info.LameDuckMode {
+		return
+	}
+	nc.log(LogLevelWarn, "server entered lame duck mode", "server", nc.currentURL(), "server_id", nc.info.ID)
+	if nc.Opts.LameDuckModeHandler != nil {
 		nc.ach.push(func() { nc.Opts.LameDuckModeHandler(nc) })
 	}
-	return nil
+	nc.emitEvent(LameDuckModeEvent, false, nil)
+	if nc.Opts.LameDuckModeReconnect {
+		nc.scheduleLameDuckModeReconnect()
+	}
 }
 
 // processAsyncInfo does the same than processInfo, but is called
@@ -3174,6 +3667,9 @@ func (nc *Conn) processErr(ie string) {
 
 	// FIXME(dlc) - process Slow Consumer signals special.
 	if e == STALE_CONNECTION {
+		nc.mu.Lock()
+		nc.log(LogLevelWarn, "stale connection reported by server", "server", nc.currentURL())
+		nc.mu.Unlock()
 		nc.processOpErr(ErrStaleConnection)
 	} else if strings.HasPrefix(e, PERMISSIONS_ERR) {
 		nc.processPermissionsViolation(ne)
@@ -3345,6 +3841,21 @@ func (nc *Conn) PublishMsg(m *Msg) error {
 	if m == nil {
 		return ErrInvalidMsg
 	}
+	return nc.publishMsgWithContext(nil, m)
+}
+
+// publishMsgWithContext is like PublishMsg, but with the context that is
+// handed to the interceptors, if any, without changing the message.
+func (nc *Conn) publishMsgWithContext(ctx context.Context, m *Msg) error {
+	// The end of the interceptor chain takes care of the headers.
+	// The interceptors get a copy, so that their changes do not
+	// affect the caller's message.
+	if nc.pubChain != nil {
+		mc := *m
+		mc.Header = cloneHeader(m.Header)
+		mc.ctx = ctx
+		return nc.pubChain(&mc)
+	}
 
 	var hdr []byte
 	var err error
@@ -3374,12 +3885,35 @@ func (nc *Conn) PublishRequest(subj, reply string, data []byte) error {
 const digits = "0123456789"
 
 // publish is the internal function to publish messages to a nats-server.
-// Sends a protocol data message by queuing into the bufio writer
-// and kicking the flush go routine. These writes should be protected.
+// If publish interceptors are configured, the message is passed through
+// the chain, otherwise it is sent directly with publishProto.
 func (nc *Conn) publish(subj, reply string, hdr, data []byte) error {
+	return nc.publishWithContext(nil, subj, reply, hdr, data)
+}
+
+// publishWithContext is like publish, but with the context
+// that is handed to the interceptors, if any.
+func (nc *Conn) publishWithContext(ctx context.Context, subj, reply string, hdr, data []byte) error {
 	if nc == nil {
 		return ErrInvalidConnection
 	}
+	if nc.pubChain != nil {
+		m := &Msg{Subject: subj, Reply: reply, Data: data, ctx: ctx}
+		if len(hdr) > 0 {
+			h, err := decodeHeadersMsg(hdr)
+			if err != nil {
+				return err
+			}
+			m.Header = h
+		}
+		return nc.pubChain(m)
+	}
+	return nc.publishProto(subj, reply, hdr, data)
+}
+
+// publishProto sends a protocol data message by queuing into the bufio
+// writer and kicking the flush go routine. These writes should be protected.
+func (nc *Conn) publishProto(subj, reply string, hdr, data []byte) error {
 	if subj == "" {
 		return ErrBadSubject
 	}
@@ -3468,6 +4002,9 @@ func (nc *Conn) publish(subj, reply string, hdr, data []byte) error {
 
 	nc.OutMsgs++
 	nc.OutBytes += uint64(len(data) + len(hdr))
+	if nc.metrics != nil {
+		nc.metrics.published(subj, len(data)+len(hdr))
+	}
 
 	if len(nc.fch) == 0 {
 		nc.kickFlusher()
@@ -3494,15 +4031,20 @@ func (nc *Conn) respHandler(m *Msg) {
 	rt := nc.respToken(m.Subject)
 	if rt != _EMPTY_ {
 		mch = nc.respMap[rt]
-		// Delete the key regardless, one response only.
-		delete(nc.respMap, rt)
+		// Delete the key regardless, one response only,
+		// unless this is a request for many responses.
+		if _, many := nc.respMany[rt]; !many {
+			delete(nc.respMap, rt)
+		}
 	} else if len(nc.respMap) == 1 {
 		// If the server has rewritten the subject, the response token (rt)
 		// will not match (could be the case with JetStream). If that is the
 		// case and there is a single entry, use that.
 		for k, v := range nc.respMap {
 			mch = v
-			delete(nc.respMap, k)
+			if _, many := nc.respMany[k]; !many {
+				delete(nc.respMap, k)
+			}
 			break
 		}
 	}
@@ -3519,37 +4061,55 @@ func (nc *Conn) respHandler(m *Msg) {
 }
 
 // Helper to setup and send new request style requests. Return the chan to receive the response.
-func (nc *Conn) createNewRequestAndSend(subj string, hdr, data []byte) (chan *Msg, string, error) {
+func (nc *Conn) createNewRequestAndSend(ctx context.Context, subj string, hdr, data []byte) (chan *Msg, string, error) {
+	mch := make(chan *Msg, RequestChanLen)
+	respInbox, token, err := nc.registerRespChan(mch, false)
+	if err != nil {
+		return nil, token, err
+	}
+
+	if err := nc.publishWithContext(ctx, subj, respInbox, hdr, data); err != nil {
+		return nil, token, err
+	}
+
+	return mch, token, nil
+}
+
+// registerRespChan creates a new literal response inbox mapped to the
+// given channel, and the response subscription if needed. If `many` is
+// true, the mapping is not removed after the first response.
+func (nc *Conn) registerRespChan(mch chan *Msg, many bool) (string, string, error) {
 	nc.mu.Lock()
 	// Do setup for the new style if needed.
 	if nc.respMap == nil {
 		nc.initNewResp()
 	}
 	// Create new literal Inbox and map to a chan msg.
-	mch := make(chan *Msg, RequestChanLen)
 	respInbox := nc.newRespInbox()
 	token := respInbox[nc.respSubLen:]
 
 	nc.respMap[token] = mch
+	if many {
+		if nc.respMany == nil {
+			nc.respMany = make(map[string]struct{})
+		}
+		nc.respMany[token] = struct{}{}
+	}
 	if nc.respMux == nil {
 		// Create the response subscription we will use for all new style responses.
 		// This will be on an _INBOX with an additional terminal token. The subscription
 		// will be on a wildcard.
-		s, err := nc.subscribeLocked(nc.respSub, _EMPTY_, nc.respHandler, nil, false, nil)
+		s, err := nc.subscribeLocked(nc.respSub, _EMPTY_, nc.respHandler, nil, false, nil, false)
 		if err != nil {
 			nc.mu.Unlock()
-			return nil, token, err
+			return _EMPTY_, token, err
 		}
 		nc.respScanf = strings.Replace(nc.respSub, "*", "%s", -1)
 		nc.respMux = s
 	}
 	nc.mu.Unlock()
 
-	if err := nc.publish(subj, respInbox, hdr, data); err != nil {
-		return nil, token, err
-	}
-
-	return mch, token, nil
+	return respInbox, token, nil
 }
 
 // RequestMsg will send a request payload including optional headers and deliver
@@ -3584,11 +4144,25 @@ func (nc *Conn) useOldRequestStyle() bool {
 	return r
 }
 
-func (nc *Conn) request(subj string, hdr, data []byte, timeout time.Duration) (*Msg, error) {
+func (nc *Conn) request(subj string, hdr, data []byte, timeout time.Duration) (m *Msg, err error) {
 	if nc == nil {
 		return nil, ErrInvalidConnection
 	}
 
+	if nc.metrics != nil {
+		defer func(start time.Time) { nc.metrics.requestDone(start, err) }(time.Now())
+	}
+
+	if nc.reqPolicy != nil {
+		return nc.reqPolicy.do(nil, subj, func(_ context.Context) (*Msg, error) {
+			return nc.requestOnce(subj, hdr, data, timeout)
+		})
+	}
+	return nc.requestOnce(subj, hdr, data, timeout)
+}
+
+// requestOnce performs a single request attempt.
+func (nc *Conn) requestOnce(subj string, hdr, data []byte, timeout time.Duration) (*Msg, error) {
 	var m *Msg
 	var err error
 
@@ -3606,7 +4180,7 @@ func (nc *Conn) request(subj string, hdr, data []byte, timeout time.Duration) (*
 }
 
 func (nc *Conn) newRequest(subj string, hdr, data []byte, timeout time.Duration) (*Msg, error) {
-	mch, token, err := nc.createNewRequestAndSend(subj, hdr, data)
+	mch, token, err := nc.createNewRequestAndSend(nil, subj, hdr, data)
 	if err != nil {
 		return nil, err
 	}
@@ -3639,7 +4213,7 @@ func (nc *Conn) oldRequest(subj string, hdr, data []byte, timeout time.Duration)
 	inbox := nc.newInbox()
 	ch := make(chan *Msg, RequestChanLen)
 
-	s, err := nc.subscribe(inbox, _EMPTY_, nil, ch, true, nil)
+	s, err := nc.subscribeRequestInbox(inbox, ch)
 	if err != nil {
 		return nil, err
 	}
@@ -3825,10 +4399,25 @@ func (nc *Conn) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSyn
 	}
 	nc.mu.Lock()
 	defer nc.mu.Unlock()
-	return nc.subscribeLocked(subj, queue, cb, ch, isSync, js)
+	return nc.subscribeLocked(subj, queue, cb, ch, isSync, js, true)
+}
+
+// subscribeRequestInbox creates the synchronous subscription on the inbox
+// receiving the response of an old style request. Delivery interceptors
+// are not invoked for it, so that they cannot break requests.
+func (nc *Conn) subscribeRequestInbox(inbox string, ch chan *Msg) (*Subscription, error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.subscribeLocked(inbox, _EMPTY_, nil, ch, true, nil, false)
 }
 
-func (nc *Conn) subscribeLocked(subj, queue string, cb MsgHandler, ch chan *Msg, isSync bool, js *jsSub) (*Subscription, error) {
+// subscribeLocked creates the subscription. The delivery interceptors,
+// if any, are invoked for its messages only if `intercept` is true.
+// Lock should be held.
+func (nc *Conn) subscribeLocked(subj, queue string, cb MsgHandler, ch chan *Msg, isSync bool, js *jsSub, intercept bool) (*Subscription, error) {
 	if nc == nil {
 		return nil, ErrInvalidConnection
 	}
@@ -3854,10 +4443,19 @@ func (nc *Conn) subscribeLocked(subj, queue string, cb MsgHandler, ch chan *Msg,
 	sub := &Subscription{
 		Subject: subj,
 		Queue:   queue,
-		mcb:     cb,
 		conn:    nc,
 		jsi:     js,
 	}
+	// Wrap the handler with the delivery interceptors, if any. Synchronous
+	// and channel based subscriptions have theirs invoked by processMsg.
+	if intercept && len(nc.Opts.DeliveryInterceptors) > 0 {
+		if cb != nil {
+			cb = chainDeliveryInterceptors(nc.Opts.DeliveryInterceptors, cb)
+		} else {
+			sub.interceptors = nc.Opts.DeliveryInterceptors
+		}
+	}
+	sub.mcb = cb
 	// Set pending limits.
 	if ch != nil {
 		sub.pMsgsLimit = cap(ch)
@@ -4079,9 +4677,7 @@ func (nc *Conn) checkDrained(sub *Subscription) {
 			if dc {
 				if err := sub.deleteConsumer(); err != nil {
 					nc.mu.Lock()
-					if errCB := nc.Opts.AsyncErrorCB; errCB != nil {
-						nc.ach.push(func() { errCB(nc, sub, err) })
-					}
+					nc.asyncError(sub, err)
 					nc.mu.Unlock()
 				}
 			}
@@ -4458,7 +5054,11 @@ func (m *Msg) Respond(data []byte) error {
 	nc := m.Sub.conn
 	m.Sub.mu.Unlock()
 	// No need to check the connection here since the call to publish will do all the checking.
-	return nc.Publish(m.Reply, data)
+	var ctx context.Context
+	if nc != nil && nc.Opts.Tracer != nil {
+		ctx = m.Context()
+	}
+	return nc.publishWithContext(ctx, m.Reply, _EMPTY_, nil, data)
 }
 
 // RespondMsg allows a convenient way to respond to requests in service based subscriptions that might include headers
@@ -4473,8 +5073,12 @@ func (m *Msg) RespondMsg(msg *Msg) error {
 	m.Sub.mu.Lock()
 	nc := m.Sub.conn
 	m.Sub.mu.Unlock()
+	var ctx context.Context
+	if nc != nil && nc.Opts.Tracer != nil {
+		ctx = m.Context()
+	}
 	// No need to check the connection here since the call to publish will do all the checking.
-	return nc.PublishMsg(msg)
+	return nc.publishMsgWithContext(ctx, msg)
 }
 
 // FIXME: This is a hack
@@ -4499,6 +5103,9 @@ func (nc *Conn) removeFlushEntry(ch chan struct{}) bool {
 // The lock must be held entering this function.
 func (nc *Conn) sendPing(ch chan struct{}) {
 	nc.pongs = append(nc.pongs, ch)
+	if nc.metrics != nil {
+		nc.metrics.pingSent(nc.status == CONNECTED)
+	}
 	nc.bw.appendString(pingProto)
 	// Flush in place.
 	nc.bw.flush()
@@ -4518,6 +5125,7 @@ func (nc *Conn) processPingTimer() {
 	// Check for violation
 	nc.pout++
 	if nc.pout > nc.Opts.MaxPingsOut {
+		nc.log(LogLevelWarn, "stale connection detected", "server", nc.currentURL(), "pings_outstanding", nc.pout-1)
 		nc.mu.Unlock()
 		nc.processOpErr(ErrStaleConnection)
 		return
@@ -4649,6 +5257,9 @@ func (nc *Conn) clearPendingFlushCalls() {
 		}
 	}
 	nc.pongs = nil
+	if nc.metrics != nil {
+		nc.metrics.clearPings()
+	}
 }
 
 // This will clear any pending Request calls.
@@ -4677,6 +5288,9 @@ func (nc *Conn) close(status Status, doCBs bool, err error) {
 		return
 	}
 	nc.status = CLOSED
+	if status == CLOSED {
+		nc.log(LogLevelInfo, "connection closed", "server", nc.currentURL())
+	}
 
 	// Kick the Go routines so they fall out.
 	nc.kickFlusher()
@@ -4698,6 +5312,17 @@ func (nc *Conn) close(status Status, doCBs bool, err error) {
 	nc.stopPingTimer()
 	nc.ptmr = nil
 
+	// Cancel the reconnect scheduled on lame duck mode, if any.
+	if nc.ldmTimer != nil {
+		nc.ldmTimer.Stop()
+		nc.ldmTimer = nil
+	}
+
+	// Remove the reconnect journal, if any.
+	if nc.bw != nil && nc.bw.journal != nil {
+		nc.bw.journal.remove()
+	}
+
 	// Need to close and set tcp conn to nil if reconnect loop has stopped,
 	// otherwise we would incorrectly invoke Disconnect handler (if set)
 	// down below.
@@ -4753,6 +5378,11 @@ func (nc *Conn) close(status Status, doCBs bool, err error) {
 	// If this is terminal, then we have to notify the asyncCB handler that
 	// it can exit once all async cbs have been dispatched.
 	if status == CLOSED {
+		// Listeners are always notified, and their channels closed.
+		if nc.conn != nil {
+			nc.emitEvent(DisconnectedEvent, false, err)
+		}
+		nc.emitEvent(ClosedEvent, true, err)
 		nc.ach.close()
 	}
 	nc.mu.Unlock()
@@ -4820,7 +5450,6 @@ func (nc *Conn) drainConnection() {
 		}
 		subs = append(subs, s)
 	}
-	errCB := nc.Opts.AsyncErrorCB
 	drainWait := nc.Opts.DrainTimeout
 	respMux := nc.respMux
 	nc.mu.Unlock()
@@ -4829,9 +5458,7 @@ func (nc *Conn) drainConnection() {
 	pushErr := func(err error) {
 		nc.mu.Lock()
 		nc.err = err
-		if errCB != nil {
-			nc.ach.push(func() { errCB(nc, nil, err) })
-		}
+		nc.asyncError(nil, err)
 		nc.mu.Unlock()
 	}
 
@@ -4881,6 +5508,7 @@ func (nc *Conn) drainConnection() {
 	// Flip State
 	nc.mu.Lock()
 	nc.status = DRAINING_PUBS
+	nc.emitEvent(DrainingEvent, true, nil)
 	nc.mu.Unlock()
 
 	// Do publish drain via Flush() call.
@@ -4916,6 +5544,8 @@ func (nc *Conn) Drain() error {
 		return nil
 	}
 	nc.status = DRAINING_SUBS
+	nc.emitEvent(DrainingEvent, true, nil)
+	nc.log(LogLevelInfo, "draining connection", "server", nc.currentURL())
 	go nc.drainConnection()
 	nc.mu.Unlock()
 
@@ -4938,7 +5568,7 @@ func (nc *Conn) getServers(implicitOnly bool) []string {
 			continue
 		}
 		url := nc.srvPool[i].url
-		servers = append(servers, fmt.Sprintf("%s://%s", url.Scheme, url.Host))
+		servers = append(servers, fmt.Sprintf("%s://%s", url.Scheme, serverAddr(url)))
 	}
 	return servers
 }
@@ -0,0 +1,383 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natstest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go/subject"
+)
+
+// Protocol errors, as sent by the NATS server.
+const (
+	errUnknownOp      = "Unknown Protocol Operation"
+	errAuthorization  = "Authorization Violation"
+	errMaxPayload     = "Maximum Payload Violation"
+	errInvalidPubSubj = "Invalid Publish Subject"
+	errInvalidSubj    = "Invalid Subject"
+	errProtocol       = "Protocol Error"
+)
+
+// connectOpts are the fields of the CONNECT protocol used by the server.
+type connectOpts struct {
+	Verbose      bool   `json:"verbose"`
+	Echo         bool   `json:"echo"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	User         string `json:"user"`
+	Pass         string `json:"pass"`
+	Token        string `json:"auth_token"`
+}
+
+// client is the server side of a client connection. The read loop
+// processes the protocols, and the write loop sends the pending data,
+// so that writes never block reads, as with net.Pipe.
+type client struct {
+	srv  *Server
+	cid  uint64
+	conn net.Conn
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	out       []byte
+	closed    bool
+	connected bool
+	opts      connectOpts
+	subs      map[string]*subscription
+}
+
+type subscription struct {
+	c         *client
+	subject   string
+	queue     string
+	sid       string
+	max       uint64 // protected by c.mu
+	delivered uint64 // protected by c.mu
+}
+
+func newClient(s *Server, cid uint64, conn net.Conn) *client {
+	c := &client{
+		srv:  s,
+		cid:  cid,
+		conn: conn,
+		opts: connectOpts{Echo: true},
+		subs: make(map[string]*subscription),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *client) echo() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opts.Echo
+}
+
+func (c *client) noResponders() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opts.Headers && c.opts.NoResponders && !c.srv.opts.NoHeaders
+}
+
+// send queues the protocol to be written.
+func (c *client) send(proto string) {
+	c.mu.Lock()
+	if !c.closed {
+		c.out = append(c.out, proto...)
+		c.cond.Signal()
+	}
+	c.mu.Unlock()
+}
+
+// sendErr sends the -ERR protocol and, if fatal, closes
+// the connection once it is written.
+func (c *client) sendErr(e string, fatal bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.out = append(c.out, "-ERR '"+e+"'\r\n"...)
+	c.closed = fatal
+	c.cond.Signal()
+}
+
+// close closes the connection, dropping the pending data.
+func (c *client) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.out = nil
+	c.cond.Signal()
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+func (c *client) writeLoop() {
+	c.mu.Lock()
+	for {
+		for len(c.out) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		out, closed := c.out, c.closed
+		c.out = nil
+		c.mu.Unlock()
+		if len(out) > 0 {
+			if _, err := c.conn.Write(out); err != nil {
+				closed = true
+			}
+		}
+		if closed {
+			c.conn.Close()
+			return
+		}
+		c.mu.Lock()
+	}
+}
+
+func (c *client) readLoop() {
+	br := bufio.NewReaderSize(c.conn, 32*1024)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		op := strings.ToUpper(args[0])
+		c.mu.Lock()
+		authorized := c.connected || (c.srv.opts.Token == "" && c.srv.opts.User == "")
+		c.mu.Unlock()
+		if !authorized && op != "CONNECT" {
+			c.sendErr(errAuthorization, true)
+			return
+		}
+		switch op {
+		case "CONNECT":
+			err = c.processConnect(strings.TrimSpace(line[len(args[0]):]))
+		case "PING":
+			c.send("PONG\r\n")
+		case "PONG":
+		case "PUB":
+			err = c.processPub(br, args[1:], false)
+		case "HPUB":
+			if c.srv.opts.NoHeaders {
+				c.sendErr(errUnknownOp, true)
+				return
+			}
+			err = c.processPub(br, args[1:], true)
+		case "SUB":
+			err = c.processSub(args[1:])
+		case "UNSUB":
+			err = c.processUnsub(args[1:])
+		default:
+			c.sendErr(errUnknownOp, true)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// protoErr is returned by the processing of a protocol that
+// closed the connection.
+type protoErr string
+
+func (e protoErr) Error() string { return string(e) }
+
+// fatal sends the error and returns it, to stop the read loop.
+func (c *client) fatal(e string) error {
+	c.sendErr(e, true)
+	return protoErr(e)
+}
+
+func (c *client) ok() {
+	c.mu.Lock()
+	verbose := c.opts.Verbose
+	c.mu.Unlock()
+	if verbose {
+		c.send("+OK\r\n")
+	}
+}
+
+func (c *client) processConnect(arg string) error {
+	opts := connectOpts{Echo: true}
+	if err := json.Unmarshal([]byte(arg), &opts); err != nil {
+		return c.fatal(errProtocol)
+	}
+	so := &c.srv.opts
+	if (so.Token != "" && opts.Token != so.Token) ||
+		(so.User != "" && (opts.User != so.User || opts.Pass != so.Password)) {
+		return c.fatal(errAuthorization)
+	}
+	c.mu.Lock()
+	c.opts = opts
+	c.connected = true
+	c.mu.Unlock()
+	c.ok()
+	return nil
+}
+
+// processPub processes PUB <subject> [reply] <size> and
+// HPUB <subject> [reply] <header size> <total size>.
+func (c *client) processPub(br *bufio.Reader, args []string, headers bool) error {
+	n := 2
+	if headers {
+		n = 3
+	}
+	if len(args) != n && len(args) != n+1 {
+		return c.fatal(errProtocol)
+	}
+	subj, reply := args[0], ""
+	if len(args) == n+1 {
+		reply = args[1]
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || size < 0 {
+		return c.fatal(errProtocol)
+	}
+	hdrSize := 0
+	if headers {
+		if hdrSize, err = strconv.Atoi(args[len(args)-2]); err != nil || hdrSize < 0 || hdrSize > size {
+			return c.fatal(errProtocol)
+		}
+	}
+	if int64(size) > c.srv.opts.MaxPayload {
+		return c.fatal(errMaxPayload)
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return err
+	}
+	if !subject.IsValidSubject(subj) || !subject.IsLiteral(subj) {
+		c.sendErr(errInvalidPubSubj, false)
+		return nil
+	}
+	var hdr []byte
+	if headers {
+		hdr = payload[:hdrSize]
+	}
+	c.srv.route(c, subj, reply, hdr, payload[hdrSize:size])
+	c.ok()
+	return nil
+}
+
+// processSub processes SUB <subject> [queue] <sid>.
+func (c *client) processSub(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return c.fatal(errProtocol)
+	}
+	sub := &subscription{c: c, subject: args[0], sid: args[len(args)-1]}
+	if len(args) == 3 {
+		sub.queue = args[1]
+	}
+	c.mu.Lock()
+	if _, ok := c.subs[sub.sid]; ok {
+		// Duplicate sid, ignored like the server does.
+		c.mu.Unlock()
+		c.ok()
+		return nil
+	}
+	if err := c.srv.addSub(sub); err != nil {
+		c.mu.Unlock()
+		c.sendErr(errInvalidSubj, false)
+		return nil
+	}
+	c.subs[sub.sid] = sub
+	c.mu.Unlock()
+	c.ok()
+	return nil
+}
+
+// processUnsub processes UNSUB <sid> [max].
+func (c *client) processUnsub(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return c.fatal(errProtocol)
+	}
+	var max uint64
+	if len(args) == 2 {
+		n, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return c.fatal(errProtocol)
+		}
+		max = n
+	}
+	c.mu.Lock()
+	if sub, ok := c.subs[args[0]]; ok {
+		if max > 0 && max > sub.delivered {
+			sub.max = max
+		} else {
+			c.removeSubLocked(sub)
+		}
+	}
+	c.mu.Unlock()
+	c.ok()
+	return nil
+}
+
+// Lock should be held.
+func (c *client) removeSubLocked(sub *subscription) {
+	delete(c.subs, sub.sid)
+	c.srv.removeSub(sub)
+}
+
+// deliver sends the message to the subscription, and returns
+// false if the subscription is no longer active.
+func (sub *subscription) deliver(subj, reply string, hdr, msg []byte) bool {
+	c := sub.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.subs[sub.sid] != sub {
+		return false
+	}
+	sub.delivered++
+	if sub.max > 0 && sub.delivered >= sub.max {
+		c.removeSubLocked(sub)
+	}
+	if !c.opts.Headers || c.srv.opts.NoHeaders {
+		hdr = nil
+	}
+	if hdr != nil {
+		c.out = append(c.out, "HMSG "...)
+	} else {
+		c.out = append(c.out, "MSG "...)
+	}
+	c.out = append(c.out, subj...)
+	c.out = append(c.out, ' ')
+	c.out = append(c.out, sub.sid...)
+	c.out = append(c.out, ' ')
+	if reply != "" {
+		c.out = append(c.out, reply...)
+		c.out = append(c.out, ' ')
+	}
+	if hdr != nil {
+		c.out = strconv.AppendInt(c.out, int64(len(hdr)), 10)
+		c.out = append(c.out, ' ')
+	}
+	c.out = strconv.AppendInt(c.out, int64(len(hdr)+len(msg)), 10)
+	c.out = append(c.out, "\r\n"...)
+	c.out = append(c.out, hdr...)
+	c.out = append(c.out, msg...)
+	c.out = append(c.out, "\r\n"...)
+	c.cond.Signal()
+	return true
+}
@@ -0,0 +1,305 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natstest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var ErrDialRefused = errors.New("natstest: dial refused")
+
+// Faults describes the faults injected in the connections of a
+// FaultDialer. The zero value injects no fault.
+type Faults struct {
+	// Latency delays each read and write.
+	Latency time.Duration
+
+	// Bandwidth limits the reads and writes of each connection to the
+	// given number of bytes per second. Zero means no limit.
+	Bandwidth int
+
+	// DropWrites silently discards the data written, as if it was
+	// lost on the way to the server.
+	DropWrites bool
+
+	// TruncateWrites, if positive, sends at most that many bytes of each
+	// write, which then fails with io.ErrShortWrite.
+	TruncateWrites int
+
+	// StallReads holds the data read until the faults are changed,
+	// the connection is closed or the read deadline expires.
+	StallReads bool
+
+	// StallWrites blocks writes until the faults are changed,
+	// the connection is closed or the write deadline expires.
+	StallWrites bool
+
+	// RefuseDials makes new connections fail with ErrDialRefused.
+	RefuseDials bool
+
+	// DisconnectAfter, if positive, closes new connections after
+	// that duration.
+	DisconnectAfter time.Duration
+}
+
+// FaultDialer is a nats.CustomDialer injecting faults in the connections
+// it dials, to test how the client handles slow, lossy or broken
+// connections. The faults can be changed at any time, and apply to the
+// existing connections as well as to the new ones.
+//
+//	d := natstest.NewFaultDialer(nil)
+//	nc, _ := nats.Connect(s.ClientURL(), nats.SetCustomDialer(d))
+//	d.SetFaults(natstest.Faults{DropWrites: true})
+type FaultDialer struct {
+	dialer nats.CustomDialer
+
+	mu      sync.Mutex
+	faults  Faults
+	changed chan struct{}
+	conns   map[*faultConn]struct{}
+}
+
+// NewFaultDialer returns a FaultDialer dialing with the given dialer,
+// or a net.Dialer if nil.
+func NewFaultDialer(dialer nats.CustomDialer) *FaultDialer {
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: nats.DefaultTimeout}
+	}
+	return &FaultDialer{
+		dialer:  dialer,
+		changed: make(chan struct{}),
+		conns:   make(map[*faultConn]struct{}),
+	}
+}
+
+// Dial implements nats.CustomDialer.
+func (d *FaultDialer) Dial(network, address string) (net.Conn, error) {
+	f, _ := d.current()
+	if f.RefuseDials {
+		return nil, ErrDialRefused
+	}
+	conn, err := d.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := &faultConn{Conn: conn, d: d, done: make(chan struct{})}
+	d.mu.Lock()
+	d.conns[c] = struct{}{}
+	d.mu.Unlock()
+	if f.DisconnectAfter > 0 {
+		c.mu.Lock()
+		c.timer = time.AfterFunc(f.DisconnectAfter, func() { c.Close() })
+		c.mu.Unlock()
+	}
+	return c, nil
+}
+
+// Faults returns the faults currently injected.
+func (d *FaultDialer) Faults() Faults {
+	f, _ := d.current()
+	return f
+}
+
+// SetFaults replaces the faults injected, waking up the stalled
+// reads and writes.
+func (d *FaultDialer) SetFaults(f Faults) {
+	d.mu.Lock()
+	d.faults = f
+	close(d.changed)
+	d.changed = make(chan struct{})
+	d.mu.Unlock()
+}
+
+// Schedule sets the faults after the delay. The returned
+// timer can be used to cancel it.
+func (d *FaultDialer) Schedule(delay time.Duration, f Faults) *time.Timer {
+	return time.AfterFunc(delay, func() { d.SetFaults(f) })
+}
+
+// Disconnect closes all the connections dialed.
+func (d *FaultDialer) Disconnect() {
+	d.mu.Lock()
+	conns := make([]*faultConn, 0, len(d.conns))
+	for c := range d.conns {
+		conns = append(conns, c)
+	}
+	d.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// NumConns returns the number of connections dialed and not closed.
+func (d *FaultDialer) NumConns() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+// current returns the faults and a channel closed when they change.
+func (d *FaultDialer) current() (Faults, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.faults, d.changed
+}
+
+type faultConn struct {
+	net.Conn
+	d    *FaultDialer
+	done chan struct{}
+	once sync.Once
+
+	mu    sync.Mutex
+	timer *time.Timer
+	rdl   time.Time
+	wdl   time.Time
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n == 0 {
+		return n, err
+	}
+	f, changed := c.d.current()
+	for f.StallReads {
+		if werr := c.wait(-1, changed, c.readDeadline()); werr != nil {
+			return 0, werr
+		}
+		f, changed = c.d.current()
+	}
+	if werr := c.wait(transferTime(f, n), nil, c.readDeadline()); werr != nil {
+		return 0, werr
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	f, changed := c.d.current()
+	for f.StallWrites {
+		if err := c.wait(-1, changed, c.writeDeadline()); err != nil {
+			return 0, err
+		}
+		f, changed = c.d.current()
+	}
+	if err := c.wait(transferTime(f, len(b)), nil, c.writeDeadline()); err != nil {
+		return 0, err
+	}
+	switch {
+	case f.DropWrites:
+		return len(b), nil
+	case f.TruncateWrites > 0 && len(b) > f.TruncateWrites:
+		n, err := c.Conn.Write(b[:f.TruncateWrites])
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return n, err
+	}
+	return c.Conn.Write(b)
+}
+
+// transferTime returns the delay of a read or write of n bytes.
+func transferTime(f Faults, n int) time.Duration {
+	d := f.Latency
+	if f.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(f.Bandwidth)
+	}
+	return d
+}
+
+// wait blocks for the duration, or until `wake` is closed if the
+// duration is negative. It fails if the connection is closed or the
+// deadline expires before.
+func (c *faultConn) wait(dur time.Duration, wake <-chan struct{}, deadline time.Time) error {
+	if dur == 0 {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if dur > 0 {
+		t := time.NewTimer(dur)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-timeout:
+	case <-wake:
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	case <-c.done:
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func (c *faultConn) readDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rdl
+}
+
+func (c *faultConn) writeDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wdl
+}
+
+func (c *faultConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl, c.wdl = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *faultConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *faultConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wdl = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *faultConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.mu.Unlock()
+		c.d.mu.Lock()
+		delete(c.d.conns, c)
+		c.d.mu.Unlock()
+		err = c.Conn.Close()
+	})
+	return err
+}
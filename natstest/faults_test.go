@@ -0,0 +1,138 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestFaultDialerStaleConnection(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	d := NewFaultDialer(nil)
+	errCh := make(chan error, 4)
+	reconnected := make(chan struct{}, 4)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.SetCustomDialer(d),
+		nats.PingInterval(20*time.Millisecond),
+		nats.MaxPingsOutstanding(2),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) { errCh <- err }),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnected <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// The PINGs are lost, and reconnecting is not possible.
+	d.SetFaults(Faults{DropWrites: true, RefuseDials: true})
+	select {
+	case err := <-errCh:
+		if err != nats.ErrStaleConnection {
+			t.Fatalf("Expected %v, got %v", nats.ErrStaleConnection, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not detect the stale connection")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !nc.IsReconnecting() {
+		t.Fatal("Expected to be reconnecting")
+	}
+
+	d.SetFaults(Faults{})
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not reconnect")
+	}
+	if n := d.NumConns(); n != 1 {
+		t.Fatalf("Expected 1 connection, got %v", n)
+	}
+}
+
+func TestFaultDialerFlusherTimeout(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	d := NewFaultDialer(nil)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.SetCustomDialer(d),
+		nats.FlusherTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// The flusher fails to write, and records the timeout.
+	d.SetFaults(Faults{StallWrites: true})
+	nc.Publish("foo", []byte("hello"))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := nc.LastError()
+		if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a timeout error, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFaultDialerDisconnect(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	d := NewFaultDialer(nil)
+	reconnected := make(chan struct{}, 4)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.SetCustomDialer(d),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnected <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// On demand, then on a schedule.
+	d.Disconnect()
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not reconnect")
+	}
+	d.SetFaults(Faults{DisconnectAfter: 50 * time.Millisecond})
+	d.Disconnect()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reconnected:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not reconnect")
+		}
+		// The next connection is not closed.
+		d.SetFaults(Faults{})
+	}
+	d.SetFaults(Faults{Latency: 50 * time.Millisecond})
+	start := time.Now()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	if dur := time.Since(start); dur < 100*time.Millisecond {
+		t.Fatalf("Expected a round trip of at least 100ms, got %v", dur)
+	}
+}
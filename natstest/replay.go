@@ -0,0 +1,195 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natstest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+var ErrReplayDone = errors.New("natstest: no more connections to replay")
+
+// Replayer is a fake server replaying a capture written by a
+// nats.ProtocolRecorder, to reproduce the messages delivered to a client.
+//
+// Each connection requested from the Replayer plays the next connection
+// of the capture: the data received by the recorded client is sent as
+// is, each record once the client sent as many protocol operations as
+// the recorded client did before receiving it. A client doing the same
+// operations, in particular subscribing in the same order, then gets the
+// same messages. Timings are not reproduced. The connection is closed at
+// the end of each connection of the capture except the last one, so that
+// the client reconnects and replays the next one.
+//
+//	rp, _ := natstest.NewReplayer(capture)
+//	nc, _ := nats.Connect(nats.DefaultURL, nats.InProcessServer(rp))
+type Replayer struct {
+	mu    sync.Mutex
+	conns [][]*nats.CaptureRecord
+}
+
+// NewReplayer reads the capture to replay.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	rp := &Replayer{}
+	cr := nats.NewCaptureReader(r)
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.Dir == nats.CaptureConnect {
+			rp.conns = append(rp.conns, nil)
+			continue
+		}
+		if len(rp.conns) == 0 {
+			return nil, nats.ErrInvalidCapture
+		}
+		last := len(rp.conns) - 1
+		rp.conns[last] = append(rp.conns[last], rec)
+	}
+	return rp, nil
+}
+
+// InProcessConn returns a connection replaying the next connection
+// of the capture, or ErrReplayDone if there is none left.
+func (rp *Replayer) InProcessConn() (net.Conn, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if len(rp.conns) == 0 {
+		return nil, ErrReplayDone
+	}
+	recs := rp.conns[0]
+	rp.conns = rp.conns[1:]
+	cli, srv := net.Pipe()
+	go replay(srv, recs, len(rp.conns) == 0)
+	return cli, nil
+}
+
+func replay(conn net.Conn, recs []*nats.CaptureRecord, last bool) {
+	defer conn.Close()
+
+	// Count the operations sent by the client.
+	var (
+		mu     sync.Mutex
+		cond   = sync.NewCond(&mu)
+		ops    int
+		closed bool
+	)
+	go func() {
+		var oc opCounter
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buf)
+			mu.Lock()
+			ops += oc.feed(buf[:n])
+			closed = err != nil
+			cond.Broadcast()
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// waitOps waits for the client to send n operations, and
+	// returns false if the connection is closed before.
+	waitOps := func(n int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for ops < n && !closed {
+			cond.Wait()
+		}
+		return ops >= n
+	}
+	waitClosed := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for !closed {
+			cond.Wait()
+		}
+	}
+
+	var sent opCounter
+	var target int
+	for _, rec := range recs {
+		switch rec.Dir {
+		case nats.CaptureSent:
+			target += sent.feed(rec.Data)
+		case nats.CaptureReceived:
+			if !waitOps(target) {
+				return
+			}
+			if _, err := conn.Write(rec.Data); err != nil {
+				return
+			}
+		}
+	}
+	if last {
+		// Keep the connection until the client closes it.
+		waitClosed()
+		return
+	}
+	waitOps(target)
+}
+
+// opCounter counts the protocol operations of a stream.
+type opCounter struct {
+	line []byte
+	skip int
+}
+
+// feed returns the number of operations completed by the data.
+func (oc *opCounter) feed(b []byte) int {
+	var n int
+	for len(b) > 0 {
+		if oc.skip > 0 {
+			k := len(b)
+			if k > oc.skip {
+				k = oc.skip
+			}
+			oc.skip -= k
+			b = b[k:]
+			continue
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			oc.line = append(oc.line, b...)
+			break
+		}
+		oc.line = append(oc.line, b[:i+1]...)
+		b = b[i+1:]
+		args := strings.Fields(string(oc.line))
+		oc.line = oc.line[:0]
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PUB", "HPUB", "MSG", "HMSG":
+			if size, err := strconv.Atoi(args[len(args)-1]); err == nil && size >= 0 {
+				oc.skip = size + 2
+			}
+		}
+		n++
+	}
+	return n
+}
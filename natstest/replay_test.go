@@ -0,0 +1,153 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natstest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// exchange subscribes, publishes and returns the messages received.
+func exchange(t *testing.T, opts ...nats.Option) []*nats.Msg {
+	t.Helper()
+	nc, err := nats.Connect(nats.DefaultURL, opts...)
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	return exchangeOn(t, nc)
+}
+
+func exchangeOn(t *testing.T, nc *nats.Conn) []*nats.Msg {
+	t.Helper()
+	sub, err := nc.SubscribeSync("foo.*")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		msg := nats.NewMsg(fmt.Sprintf("foo.%d", i))
+		msg.Header.Set("Index", fmt.Sprint(i))
+		msg.Data = []byte(fmt.Sprintf("hello %d", i))
+		nc.PublishMsg(msg)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	var msgs []*nats.Msg
+	for i := 0; i < 3; i++ {
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message: %v", err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	s := runServer(t, &Options{User: "user", Password: "secretpwd"})
+	defer s.Shutdown()
+
+	for _, redact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("redact=%v", redact), func(t *testing.T) {
+			capture := &bytes.Buffer{}
+			rec := nats.NewProtocolRecorder(capture, redact)
+			exchange(t, nats.InProcessServer(s), nats.UserInfo("user", "secretpwd"), nats.RecordProtocol(rec))
+			if err := rec.Err(); err != nil {
+				t.Fatalf("Error recording: %v", err)
+			}
+			if bytes.Contains(capture.Bytes(), []byte("secretpwd")) {
+				t.Fatal("Password was recorded")
+			}
+			if hello := bytes.Contains(capture.Bytes(), []byte("hello")); hello == redact {
+				t.Fatalf("Unexpected payloads in capture:\n%s", capture)
+			}
+
+			// The capture starts with a connection record.
+			cr := nats.NewCaptureReader(bytes.NewReader(capture.Bytes()))
+			var n int
+			for {
+				r, err := cr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Error reading capture: %v", err)
+				}
+				if n == 0 && r.Dir != nats.CaptureConnect {
+					t.Fatalf("Unexpected first record: %+v", r)
+				}
+				n++
+			}
+
+			rp, err := NewReplayer(bytes.NewReader(capture.Bytes()))
+			if err != nil {
+				t.Fatalf("Error reading capture: %v", err)
+			}
+			msgs := exchange(t, nats.InProcessServer(rp), nats.UserInfo("user", "other"))
+			for i, m := range msgs {
+				data := fmt.Sprintf("hello %d", i)
+				if redact {
+					data = "xxxxxxx"
+				}
+				if m.Subject != fmt.Sprintf("foo.%d", i) || string(m.Data) != data || m.Header.Get("Index") != fmt.Sprint(i) {
+					t.Fatalf("Unexpected message: %+v", m)
+				}
+			}
+			if _, err := rp.InProcessConn(); err != ErrReplayDone {
+				t.Fatalf("Expected %v, got %v", ErrReplayDone, err)
+			}
+		})
+	}
+
+	if _, err := NewReplayer(bytes.NewReader([]byte("garbage\n"))); err != nats.ErrInvalidCapture {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidCapture, err)
+	}
+}
+
+func TestReplayKeepsLastConnection(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	capture := &bytes.Buffer{}
+	exchange(t, nats.InProcessServer(s), nats.RecordProtocol(nats.NewProtocolRecorder(capture, false)))
+	rp, err := NewReplayer(capture)
+	if err != nil {
+		t.Fatalf("Error reading capture: %v", err)
+	}
+
+	disconnected := make(chan error, 1)
+	nc, err := nats.Connect(nats.DefaultURL, nats.InProcessServer(rp),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) { disconnected <- err }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	exchangeOn(t, nc)
+
+	// The connection is kept after the last record.
+	select {
+	case err := <-disconnected:
+		t.Fatalf("Replayed connection was closed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !nc.IsConnected() {
+		t.Fatalf("Expected to be connected, got %v", nc.Status())
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package natstest provides an in-memory NATS server for unit tests.
+//
+// The server implements the core client protocol: publish and subscribe
+// with wildcards and queue groups, headers, request/reply with no
+// responders, and PING/PONG. It has no clustering, JetStream, TLS or
+// accounts. Clients connect to it over a loopback listener or, with the
+// nats.InProcessServer option, over net.Pipe:
+//
+//	s, _ := natstest.NewServer(nil)
+//	defer s.Shutdown()
+//	nc, _ := nats.Connect(s.ClientURL())
+//
+// FaultDialer injects network faults in the connections of a client,
+// to test its behavior on slow or broken networks.
+package natstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/nats-io/nats.go/subject"
+	"github.com/nats-io/nuid"
+)
+
+// DefaultMaxPayload is the maximum payload size when not configured.
+const DefaultMaxPayload = 1024 * 1024
+
+var ErrServerClosed = errors.New("natstest: server closed")
+
+// Options configures a Server.
+type Options struct {
+	// MaxPayload is the maximum size of a message payload and headers.
+	// Defaults to DefaultMaxPayload.
+	MaxPayload int64
+
+	// NoHeaders disables support for message headers.
+	NoHeaders bool
+
+	// Token, if set, must be given by the clients to connect.
+	Token string
+
+	// User and Password, if set, must be given by the clients to connect.
+	User     string
+	Password string
+}
+
+// Server is an in-memory NATS server.
+type Server struct {
+	mu      sync.Mutex
+	opts    Options
+	id      string
+	l       net.Listener
+	sl      *subject.Sublist
+	clients map[*client]struct{}
+	cid     uint64
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// info is the INFO protocol sent to the clients.
+type info struct {
+	ID           string `json:"server_id"`
+	Name         string `json:"server_name"`
+	Proto        int    `json:"proto"`
+	Version      string `json:"version"`
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+	Headers      bool   `json:"headers"`
+	AuthRequired bool   `json:"auth_required,omitempty"`
+	MaxPayload   int64  `json:"max_payload"`
+	CID          uint64 `json:"client_id"`
+}
+
+// NewServer starts a server listening on an ephemeral loopback port.
+// The options may be nil.
+func NewServer(opts *Options) (*Server, error) {
+	s := &Server{
+		id:      nuid.Next(),
+		sl:      subject.NewSublist(),
+		clients: make(map[*client]struct{}),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.MaxPayload <= 0 {
+		s.opts.MaxPayload = DefaultMaxPayload
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("natstest: error listening: %v", err)
+	}
+	s.l = l
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+// ClientURL returns the URL to connect to the server.
+func (s *Server) ClientURL() string {
+	return "nats://" + s.l.Addr().String()
+}
+
+// InProcessConn returns the client side of a new in-memory connection to
+// the server, so that the server can be given to nats.InProcessServer.
+func (s *Server) InProcessConn() (net.Conn, error) {
+	cli, srv := net.Pipe()
+	if err := s.serve(srv); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+// NumClients returns the number of connected clients.
+func (s *Server) NumClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// NumSubscriptions returns the number of subscriptions of all clients.
+func (s *Server) NumSubscriptions() int {
+	return s.sl.Count()
+}
+
+// CloseClients closes the connections of all clients, which then
+// reconnect if they are configured to.
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	for _, c := range clients {
+		c.close()
+	}
+}
+
+// Shutdown stops the server and closes the connections of all clients.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+	s.l.Close()
+	s.CloseClients()
+	s.wg.Wait()
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		if s.serve(conn) != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// serve registers a client for the connection and starts its loops.
+func (s *Server) serve(conn net.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	s.cid++
+	c := newClient(s, s.cid, conn)
+	s.clients[c] = struct{}{}
+	host, port := "127.0.0.1", 0
+	if addr, ok := s.l.Addr().(*net.TCPAddr); ok {
+		host, port = addr.IP.String(), addr.Port
+	}
+	inf, _ := json.Marshal(&info{
+		ID:           s.id,
+		Name:         "natstest",
+		Proto:        1,
+		Version:      "2.9.0",
+		Host:         host,
+		Port:         port,
+		Headers:      !s.opts.NoHeaders,
+		AuthRequired: s.opts.Token != "" || s.opts.User != "",
+		MaxPayload:   s.opts.MaxPayload,
+		CID:          c.cid,
+	})
+	c.send("INFO " + string(inf) + "\r\n")
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		c.writeLoop()
+	}()
+	go func() {
+		defer s.wg.Done()
+		c.readLoop()
+		// Let the write loop send a pending error and close.
+		c.mu.Lock()
+		c.closed = true
+		c.cond.Signal()
+		c.mu.Unlock()
+		s.removeClient(c)
+	}()
+	return nil
+}
+
+func (s *Server) removeClient(c *client) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.mu.Unlock()
+	for _, sub := range subs {
+		s.sl.Remove(sub.subject, sub)
+	}
+}
+
+// route delivers a message published by the client to the matching
+// subscriptions, one per queue group. If there is none and the message
+// is a request, a no responders status is sent back to the publisher.
+func (s *Server) route(from *client, subj, reply string, hdr, msg []byte) {
+	var queues map[string][]*subscription
+	delivered := false
+	for _, v := range s.sl.Match(subj) {
+		sub := v.(*subscription)
+		if sub.c == from && !from.echo() {
+			continue
+		}
+		if sub.queue != "" {
+			if queues == nil {
+				queues = make(map[string][]*subscription)
+			}
+			queues[sub.queue] = append(queues[sub.queue], sub)
+			continue
+		}
+		if sub.deliver(subj, reply, hdr, msg) {
+			delivered = true
+		}
+	}
+	for _, qsubs := range queues {
+		// Pick a random member, trying the others if it is done.
+		for _, i := range rand.Perm(len(qsubs)) {
+			if qsubs[i].deliver(subj, reply, hdr, msg) {
+				delivered = true
+				break
+			}
+		}
+	}
+	if !delivered && reply != "" && from.noResponders() {
+		s.noResponders(from, reply)
+	}
+}
+
+// noResponders sends the 503 status to the subscriptions
+// of the requestor matching the reply subject.
+func (s *Server) noResponders(c *client, reply string) {
+	hdr := []byte("NATS/1.0 503\r\n\r\n")
+	for _, v := range s.sl.Match(reply) {
+		if sub := v.(*subscription); sub.c == c {
+			sub.deliver(reply, "", hdr, nil)
+		}
+	}
+}
+
+func (s *Server) addSub(sub *subscription) error {
+	return s.sl.Insert(sub.subject, sub)
+}
+
+func (s *Server) removeSub(sub *subscription) {
+	s.sl.Remove(sub.subject, sub)
+}
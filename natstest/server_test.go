@@ -0,0 +1,208 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natstest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func runServer(t *testing.T, opts *Options) *Server {
+	t.Helper()
+	s, err := NewServer(opts)
+	if err != nil {
+		t.Fatalf("Error starting server: %v", err)
+	}
+	return s
+}
+
+func TestPubSub(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	subs := make(map[string]*nats.Subscription)
+	for _, filter := range []string{"foo.bar", "foo.*", "foo.>", "*.baz", "bar"} {
+		sub, err := nc.SubscribeSync(filter)
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		subs[filter] = sub
+	}
+	msg := nats.NewMsg("foo.bar")
+	msg.Header.Set("Key", "value")
+	msg.Data = []byte("hello")
+	if err := nc.PublishMsg(msg); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	nc.Flush()
+	for filter, sub := range subs {
+		n, _, _ := sub.Pending()
+		expected := 1
+		if filter == "*.baz" || filter == "bar" {
+			expected = 0
+		}
+		if n != expected {
+			t.Fatalf("Expected %v messages for %q, got %v", expected, filter, n)
+		}
+	}
+	m, err := subs["foo.>"].NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+	if m.Subject != "foo.bar" || string(m.Data) != "hello" || m.Header.Get("Key") != "value" {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+	if n := s.NumSubscriptions(); n != 5 {
+		t.Fatalf("Expected 5 subscriptions, got %v", n)
+	}
+
+	// Auto-unsubscribe.
+	sub, _ := nc.SubscribeSync("auto")
+	sub.AutoUnsubscribe(2)
+	for i := 0; i < 3; i++ {
+		nc.Publish("auto", nil)
+	}
+	nc.Flush()
+	if n, _, _ := sub.Pending(); n != 2 {
+		t.Fatalf("Expected 2 messages, got %v", n)
+	}
+	if n := s.NumSubscriptions(); n != 5 {
+		t.Fatalf("Expected 5 subscriptions, got %v", n)
+	}
+}
+
+func TestQueueGroups(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	q1, _ := nc.QueueSubscribeSync("foo", "q")
+	q2, _ := nc.QueueSubscribeSync("foo", "q")
+	plain, _ := nc.SubscribeSync("foo")
+	for i := 0; i < 100; i++ {
+		nc.Publish("foo", nil)
+	}
+	nc.Flush()
+	n1, _, _ := q1.Pending()
+	n2, _, _ := q2.Pending()
+	if n1+n2 != 100 || n1 == 0 || n2 == 0 {
+		t.Fatalf("Unexpected distribution: %v and %v", n1, n2)
+	}
+	if n, _, _ := plain.Pending(); n != 100 {
+		t.Fatalf("Expected 100 messages, got %v", n)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	// Also check connections over net.Pipe.
+	nc, err := nats.Connect(nats.DefaultURL, nats.InProcessServer(s))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	if _, err := nc.Request("nobody", nil, time.Second); err != nats.ErrNoResponders {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+	}
+	nc.Subscribe("help", func(m *nats.Msg) {
+		m.Respond([]byte("ok"))
+	})
+	resp, err := nc.Request("help", nil, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if string(resp.Data) != "ok" {
+		t.Fatalf("Unexpected response: %q", resp.Data)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	s := runServer(t, &Options{Token: "secret", MaxPayload: 16})
+	defer s.Shutdown()
+
+	_, err := nats.Connect(s.ClientURL(), nats.Token("wrong"))
+	if err == nil || !strings.Contains(strings.ToLower(err.Error()), nats.AUTHORIZATION_ERR) {
+		t.Fatalf("Expected authorization error, got %v", err)
+	}
+
+	closed := make(chan struct{})
+	nc, err := nats.Connect(s.ClientURL(), nats.Token("secret"),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	if mp := nc.MaxPayload(); mp != 16 {
+		t.Fatalf("Expected max payload of 16, got %v", mp)
+	}
+
+	// The client closes the connection on this error.
+	nc.Publish("foo.*", nil)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Connection was not closed")
+	}
+	if err := nc.LastError(); err == nil || !strings.Contains(strings.ToLower(err.Error()), "invalid publish subject") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCloseClients(t *testing.T) {
+	s := runServer(t, nil)
+	defer s.Shutdown()
+
+	reconnected := make(chan struct{}, 1)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnected <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	sub, _ := nc.SubscribeSync("foo")
+	nc.Flush()
+
+	s.CloseClients()
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not reconnect")
+	}
+	// Subscriptions are restored on reconnect.
+	nc.Publish("foo", nil)
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+	if n := s.NumClients(); n != 1 {
+		t.Fatalf("Expected 1 client, got %v", n)
+	}
+}
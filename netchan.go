@@ -46,13 +46,14 @@ func chPublish(c *EncodedConn, chVal reflect.Value, subject string) {
 			c.Conn.mu.Lock()
 			defer c.Conn.mu.Unlock()
 
-			if c.Conn.Opts.AsyncErrorCB != nil {
-				// FIXME(dlc) - Not sure this is the right thing to do.
-				// FIXME(ivan) - If the connection is not yet closed, try to schedule the callback
-				if c.Conn.isClosed() {
+			// FIXME(dlc) - Not sure this is the right thing to do.
+			// FIXME(ivan) - If the connection is not yet closed, try to schedule the callback
+			if !c.Conn.isClosed() {
+				c.Conn.asyncError(nil, e)
+			} else {
+				c.Conn.logAsyncError(nil, e)
+				if c.Conn.Opts.AsyncErrorCB != nil {
 					go c.Conn.Opts.AsyncErrorCB(c.Conn, nil, e)
-				} else {
-					c.Conn.ach.push(func() { c.Conn.Opts.AsyncErrorCB(c.Conn, nil, e) })
 				}
 			}
 			return
@@ -86,10 +87,10 @@ func (c *EncodedConn) bindRecvChan(subject, queue string, channel interface{}) (
 			oPtr = reflect.New(argType.Elem())
 		}
 		if err := c.Enc.Decode(m.Subject, m.Data, oPtr.Interface()); err != nil {
+			c.Conn.mu.Lock()
 			c.Conn.err = errors.New("nats: Got an error trying to unmarshal: " + err.Error())
-			if c.Conn.Opts.AsyncErrorCB != nil {
-				c.Conn.ach.push(func() { c.Conn.Opts.AsyncErrorCB(c.Conn, m.Sub, c.Conn.err) })
-			}
+			c.Conn.asyncError(m.Sub, c.Conn.err)
+			c.Conn.mu.Unlock()
 			return
 		}
 		if argType.Kind() != reflect.Ptr {
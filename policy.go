@@ -0,0 +1,356 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Default values of the RequestPolicy.
+const (
+	DefaultBackoffMultiplier = 2.0
+	DefaultBreakerCooldown   = 5 * time.Second
+)
+
+var ErrCircuitOpen = errors.New("nats: circuit breaker open")
+
+// RequestPolicy configures how requests made with Request, RequestMsg,
+// RequestWithContext, RequestMsgWithContext and EncodedConn.Request
+// are retried, hedged and failed fast. It does not apply to the requests
+// made by JetStream, which could otherwise be applied twice.
+type RequestPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the
+	// first one. Values lower than 1 mean a single attempt.
+	// For requests made with a timeout, the timeout applies to each attempt.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It is multiplied by
+	// BackoffMultiplier (DefaultBackoffMultiplier if 0) after every retry,
+	// up to MaxBackoff, if set.
+	Backoff           time.Duration
+	BackoffMultiplier float64
+	MaxBackoff        time.Duration
+
+	// Jitter is the fraction, between 0 and 1, of each backoff
+	// delay that is randomized.
+	Jitter float64
+
+	// AttemptTimeout, if set, bounds each attempt of requests made with
+	// a context. A timed out attempt fails with ErrTimeout.
+	AttemptTimeout time.Duration
+
+	// HedgeDelay, if set, sends a second identical request when no
+	// response was received within that delay. The first successful
+	// response of either request is returned.
+	HedgeDelay time.Duration
+
+	// BreakerThreshold, if set, is the number of consecutive failed
+	// attempts for a subject after which requests on that subject fail
+	// fast with ErrCircuitOpen. After BreakerCooldown (DefaultBreakerCooldown
+	// if 0), a single trial request is allowed: its success closes the
+	// circuit, while its failure opens it for another cooldown period.
+	// Failures are forgotten after a cooldown period without failure, or
+	// without trial request once the circuit can be closed.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Retryable reports whether a failed attempt should be retried, and is
+	// counted as a failure by the circuit breaker. Defaults to ErrTimeout
+	// and ErrNoResponders.
+	Retryable func(err error) bool
+}
+
+// SetRequestPolicy is an Option to set the policy applied to requests.
+func SetRequestPolicy(policy RequestPolicy) Option {
+	return func(o *Options) error {
+		if policy.Backoff < 0 || policy.MaxBackoff < 0 || policy.BackoffMultiplier < 0 ||
+			policy.Jitter < 0 || policy.Jitter > 1 || policy.AttemptTimeout < 0 ||
+			policy.HedgeDelay < 0 || policy.BreakerThreshold < 0 || policy.BreakerCooldown < 0 {
+			return ErrInvalidArg
+		}
+		o.RequestPolicy = &policy
+		return nil
+	}
+}
+
+type requestPolicy struct {
+	RequestPolicy
+	breakers *breakerSet
+}
+
+func newRequestPolicy(p RequestPolicy) *requestPolicy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.BackoffMultiplier == 0 {
+		p.BackoffMultiplier = DefaultBackoffMultiplier
+	}
+	if p.BreakerCooldown == 0 {
+		p.BreakerCooldown = DefaultBreakerCooldown
+	}
+	if p.Retryable == nil {
+		p.Retryable = defaultRetryable
+	}
+	rp := &requestPolicy{RequestPolicy: p}
+	if p.BreakerThreshold > 0 {
+		rp.breakers = &breakerSet{
+			threshold: p.BreakerThreshold,
+			cooldown:  p.BreakerCooldown,
+			subjects:  make(map[string]*breaker),
+		}
+	}
+	return rp
+}
+
+func defaultRetryable(err error) bool {
+	return err == ErrTimeout || err == ErrNoResponders
+}
+
+// requestAttempt performs a single request. The context is nil
+// for requests made with a timeout.
+type requestAttempt func(ctx context.Context) (*Msg, error)
+
+// do invokes `attempt` according to the policy.
+func (p *requestPolicy) do(ctx context.Context, subj string, attempt requestAttempt) (*Msg, error) {
+	var err error
+	backoff := p.Backoff
+	for i := 0; i < p.MaxAttempts; i++ {
+		if i > 0 && backoff > 0 {
+			if err := sleepWithContext(ctx, p.jitter(backoff)); err != nil {
+				return nil, err
+			}
+			backoff = time.Duration(float64(backoff) * p.BackoffMultiplier)
+			if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+		if !p.breakers.allow(subj) {
+			return nil, ErrCircuitOpen
+		}
+		var m *Msg
+		m, err = p.hedge(ctx, attempt)
+		retryable := err != nil && p.Retryable(err)
+		p.breakers.record(subj, retryable)
+		if !retryable || (ctx != nil && ctx.Err() != nil) {
+			return m, err
+		}
+	}
+	return nil, err
+}
+
+func (p *requestPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter == 0 {
+		return d
+	}
+	return d - time.Duration(rand.Float64()*p.Jitter*float64(d))
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	if ctx == nil {
+		<-t.C
+		return nil
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// single performs an attempt, bounded by AttemptTimeout if set.
+func (p *requestPolicy) single(ctx context.Context, attempt requestAttempt) (*Msg, error) {
+	if ctx == nil || p.AttemptTimeout == 0 {
+		return attempt(ctx)
+	}
+	actx, cancel := context.WithTimeout(ctx, p.AttemptTimeout)
+	defer cancel()
+	m, err := attempt(actx)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		err = ErrTimeout
+	}
+	return m, err
+}
+
+// hedge performs an attempt and, if HedgeDelay is set and no response
+// was received within that delay, a second identical one.
+func (p *requestPolicy) hedge(ctx context.Context, attempt requestAttempt) (*Msg, error) {
+	if p.HedgeDelay == 0 {
+		return p.single(ctx, attempt)
+	}
+	type result struct {
+		m   *Msg
+		err error
+	}
+	if ctx != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		// Abandon the outstanding request, if any, when returning.
+		defer cancel()
+	}
+	results := make(chan result, 2)
+	run := func() {
+		m, err := p.single(ctx, attempt)
+		results <- result{m, err}
+	}
+	go run()
+
+	t := time.NewTimer(p.HedgeDelay)
+	defer t.Stop()
+	hc := t.C
+	pending := 1
+	for {
+		select {
+		case r := <-results:
+			pending--
+			// Return on success, or if there is no other outstanding request.
+			// If the first request fails before the hedge delay, the policy
+			// retries, if configured, rather than hedging.
+			if r.err == nil || pending == 0 {
+				return r.m, r.err
+			}
+		case <-hc:
+			hc = nil
+			pending++
+			go run()
+		}
+	}
+}
+
+// Minimum number of circuit breakers before expired ones are removed.
+const minBreakerSweep = 64
+
+// breakerSet holds the circuit breakers for the subjects that recently
+// failed. Subjects are removed upon success, or once expired.
+type breakerSet struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	subjects  map[string]*breaker
+	sweepAt   int
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	expires   time.Time
+	trial     bool
+}
+
+// expired reports whether the failures of the breaker are forgotten.
+func (b *breaker) expired(now time.Time) bool {
+	return !b.trial && now.After(b.expires)
+}
+
+// allow reports whether a request can be sent on the subject.
+func (bs *breakerSet) allow(subj string) bool {
+	if bs == nil {
+		return true
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.subjects[subj]
+	if b == nil {
+		return true
+	}
+	if b.expired(time.Now()) {
+		delete(bs.subjects, subj)
+		return true
+	}
+	if b.failures < bs.threshold {
+		return true
+	}
+	// Circuit is open until the end of the cooldown, then let
+	// a single trial request go through.
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record records the outcome of an attempt on the subject.
+func (bs *breakerSet) record(subj string, failed bool) {
+	if bs == nil {
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if !failed {
+		delete(bs.subjects, subj)
+		return
+	}
+	now := time.Now()
+	b := bs.subjects[subj]
+	if b == nil || b.expired(now) {
+		bs.sweep(now)
+		b = &breaker{}
+		bs.subjects[subj] = b
+	}
+	b.failures++
+	b.trial = false
+	b.expires = now.Add(bs.cooldown)
+	if b.failures >= bs.threshold {
+		b.openUntil = b.expires
+		b.expires = b.openUntil.Add(bs.cooldown)
+	}
+}
+
+// sweep removes the expired breakers, once their number doubled
+// since the last sweep. Lock should be held.
+func (bs *breakerSet) sweep(now time.Time) {
+	if len(bs.subjects) < bs.sweepAt || len(bs.subjects) < minBreakerSweep {
+		return
+	}
+	for subj, b := range bs.subjects {
+		if b.expired(now) {
+			delete(bs.subjects, subj)
+		}
+	}
+	bs.sweepAt = 2 * len(bs.subjects)
+}
+
+// jsRequest makes a JetStream API, publish or ack request, with the
+// context if not nil, or else the timeout. The request policy does not
+// apply: retrying or hedging such a request after a timeout could apply
+// it twice, for instance storing a published message twice.
+func (nc *Conn) jsRequest(ctx context.Context, msg *Msg, timeout time.Duration) (m *Msg, err error) {
+	var hdr []byte
+	if len(msg.Header) > 0 {
+		if !nc.info.Headers {
+			return nil, ErrHeadersNotSupported
+		}
+		if hdr, err = msg.headerBytes(); err != nil {
+			return nil, err
+		}
+	}
+	if ctx != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if nc.metrics != nil {
+		defer func(start time.Time) { nc.metrics.requestDone(start, err) }(time.Now())
+	}
+
+	if ctx != nil {
+		return nc.requestWithContextOnce(ctx, msg.Subject, hdr, msg.Data)
+	}
+	return nc.requestOnce(msg.Subject, hdr, msg.Data, timeout)
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBreakerSetEviction(t *testing.T) {
+	p := newRequestPolicy(RequestPolicy{BreakerThreshold: 2, BreakerCooldown: 20 * time.Millisecond})
+	bs := p.breakers
+
+	// Subjects failing once are not kept forever.
+	for i := 0; i < 10*minBreakerSweep; i++ {
+		bs.record(fmt.Sprintf("svc.%d", i), true)
+		if i == minBreakerSweep {
+			time.Sleep(30 * time.Millisecond)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 10*minBreakerSweep; i++ {
+		bs.record(fmt.Sprintf("other.%d", i), true)
+	}
+	bs.mu.Lock()
+	n := len(bs.subjects)
+	bs.mu.Unlock()
+	if n > 2*10*minBreakerSweep {
+		t.Fatalf("Expected expired breakers to be removed, got %v", n)
+	}
+	for i := 0; i < 10*minBreakerSweep; i++ {
+		bs.mu.Lock()
+		_, ok := bs.subjects[fmt.Sprintf("svc.%d", i)]
+		bs.mu.Unlock()
+		if ok {
+			t.Fatalf("Expected breaker of svc.%d to be removed", i)
+		}
+	}
+
+	// An open circuit is not removed until a trial is possible.
+	bs.record("open", true)
+	bs.record("open", true)
+	if bs.allow("open") {
+		t.Fatal("Expected circuit to be open")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !bs.allow("open") || bs.allow("open") {
+		t.Fatal("Expected a single trial request")
+	}
+	bs.record("open", true)
+	if bs.allow("open") {
+		t.Fatal("Expected circuit to be open again")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledMsgBuf is the capacity above which the payload buffer of a
+// released message is not kept, so that a few large messages do not
+// pin memory in the pool.
+const maxPooledMsgBuf = 64 * 1024
+
+var msgPool = sync.Pool{New: func() interface{} { return &Msg{} }}
+
+// SetMsgPooling makes the subscription deliver messages taken from a
+// pool, whose payload is copied into a buffer recycled with the message,
+// to avoid allocations when receiving at a high rate.
+//
+// Each message delivered must then be released with Msg.Release once
+// done with. Neither the message nor its Data, which is not copied, may
+// be used after it is released. Messages not released are simply
+// garbage collected. Pooling is not supported for JetStream subscriptions.
+func (s *Subscription) SetMsgPooling(enabled bool) error {
+	if s == nil {
+		return ErrBadSubscription
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil || s.closed {
+		return ErrBadSubscription
+	}
+	if s.jsi != nil {
+		return ErrTypeSubscription
+	}
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&s.pooled, v)
+	return nil
+}
+
+// newPooledMsg returns a message from the pool,
+// with a copy of the data in its buffer.
+func newPooledMsg(data []byte) *Msg {
+	m := msgPool.Get().(*Msg)
+	m.pooled = true
+	m.buf = append(m.buf[:0], data...)
+	return m
+}
+
+// Release returns the message to the pool of its subscription if it
+// uses message pooling, see Subscription.SetMsgPooling. The message,
+// including its Data, must not be used after, nor released again since
+// it may have been reused. Releasing a message not coming from a pool
+// does nothing.
+func (m *Msg) Release() {
+	if m == nil || !m.pooled {
+		return
+	}
+	m.reset()
+	msgPool.Put(m)
+}
+
+// reset clears the message, keeping its buffer unless too large.
+func (m *Msg) reset() {
+	buf := m.buf
+	*m = Msg{}
+	if cap(buf) <= maxPooledMsgBuf {
+		m.buf = buf[:0]
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"testing"
+)
+
+func TestMsgReset(t *testing.T) {
+	m := newPooledMsg([]byte("hello"))
+	m.Subject, m.Reply, m.Data = "foo", "bar", m.buf
+	m.Header = Header{"Key": {"value"}}
+	m.Sub = &Subscription{}
+	if !m.pooled || string(m.buf) != "hello" {
+		t.Fatalf("Unexpected pooled message: %+v", m)
+	}
+
+	// The buffer is kept for the next message.
+	m.reset()
+	if m.pooled || m.Subject != _EMPTY_ || m.Reply != _EMPTY_ || m.Data != nil || m.Header != nil || m.Sub != nil {
+		t.Fatalf("Expected message to be reset, got %+v", m)
+	}
+	if len(m.buf) != 0 || cap(m.buf) < len("hello") {
+		t.Fatalf("Expected buffer to be kept, got len %v cap %v", len(m.buf), cap(m.buf))
+	}
+
+	// Unless too large.
+	m.buf = make([]byte, maxPooledMsgBuf+1)
+	m.reset()
+	if m.buf != nil {
+		t.Fatalf("Expected large buffer to be dropped, got cap %v", cap(m.buf))
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrProxyNotSupported = errors.New("nats: proxy scheme not supported")
+
+// ProxyFunc returns the URL of the proxy to use to connect to the given
+// server, or nil to connect directly.
+type ProxyFunc func(server *url.URL) (*url.URL, error)
+
+// Proxy is an Option to connect to the servers through the proxy with the
+// given URL. The supported schemes are "http" and "https", for proxies
+// supporting the HTTP CONNECT method, and "socks5". Credentials can be
+// given in the URL, for HTTP basic or SOCKS5 username/password
+// authentication.
+func Proxy(proxyURL string) Option {
+	return func(o *Options) error {
+		u, err := parseProxyURL(proxyURL)
+		if err != nil {
+			return err
+		}
+		o.Proxy = func(*url.URL) (*url.URL, error) { return u, nil }
+		return nil
+	}
+}
+
+// ProxyFromEnvironment is an Option to use the proxy given by the
+// HTTPS_PROXY, or lowercase https_proxy, environment variable, unless
+// the server matches NO_PROXY, like net/http does for HTTPS requests.
+// Connections to localhost are never proxied.
+func ProxyFromEnvironment() Option {
+	return func(o *Options) error {
+		o.Proxy = func(server *url.URL) (*url.URL, error) {
+			// Ask for the proxy of an HTTPS request to the server.
+			req := &http.Request{URL: &url.URL{Scheme: "https", Host: server.Host}}
+			u, err := http.ProxyFromEnvironment(req)
+			if err != nil || u == nil {
+				return nil, err
+			}
+			return parseProxyURL(u.String())
+		}
+		return nil
+	}
+}
+
+// SetProxyFunc is an Option to set the function choosing the proxy
+// to use for each server.
+func SetProxyFunc(proxy ProxyFunc) Option {
+	return func(o *Options) error {
+		o.Proxy = proxy
+		return nil
+	}
+}
+
+// parseProxyURL parses and validates the proxy URL, and sets the
+// default port of the scheme if missing.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("nats: invalid proxy URL: %v", err)
+	}
+	var port string
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	default:
+		return nil, ErrProxyNotSupported
+	}
+	if u.Hostname() == _EMPTY_ {
+		return nil, fmt.Errorf("nats: invalid proxy URL %q", proxyURL)
+	}
+	if u.Port() == _EMPTY_ {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// dialProxy connects to `addr` through the proxy, using the dialer to
+// connect to the proxy. The whole exchange with the proxy is bounded
+// by the timeout, if positive.
+func dialProxy(dialer CustomDialer, proxy *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialer.Dial("tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	tunnel := conn
+	switch proxy.Scheme {
+	case "https":
+		tconn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname(), MinVersion: tls.VersionTLS12})
+		if err = tconn.Handshake(); err == nil {
+			tunnel, err = httpConnect(tconn, proxy, addr)
+		}
+	case "http":
+		tunnel, err = httpConnect(conn, proxy, addr)
+	case "socks5", "socks5h":
+		err = socks5Connect(conn, proxy, addr)
+	default:
+		err = ErrProxyNotSupported
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// proxyConn is a connection whose first bytes were read
+// along with the proxy's response.
+type proxyConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.br.Buffered() > 0 {
+		return c.br.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// httpConnect establishes a tunnel to `addr` with the HTTP CONNECT method.
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxy.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nats: proxy refused connection to %s: %s", addr, resp.Status)
+	}
+	return &proxyConn{Conn: conn, br: br}, nil
+}
+
+// SOCKS5 protocol values, see RFC 1928 and RFC 1929.
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5UserPassAuth = 2
+	socks5NoAcceptable = 0xff
+	socks5CmdConnect   = 1
+	socks5IPv4         = 1
+	socks5Domain       = 3
+	socks5IPv6         = 4
+	socks5Succeeded    = 0
+)
+
+// socks5Connect establishes a tunnel to `addr` with the SOCKS5 CONNECT
+// command. Host names are resolved by the proxy.
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return fmt.Errorf("nats: invalid port %q", portStr)
+	}
+
+	methods := []byte{socks5NoAuth}
+	if proxy.User != nil {
+		methods = append(methods, socks5UserPassAuth)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var buf [2]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("nats: unexpected SOCKS version %v", buf[0])
+	}
+	switch buf[1] {
+	case socks5NoAuth:
+	case socks5UserPassAuth:
+		if proxy.User == nil {
+			return errors.New("nats: SOCKS proxy requires authentication")
+		}
+		user := proxy.User.Username()
+		pass, _ := proxy.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return errors.New("nats: SOCKS username or password too long")
+		}
+		req := []byte{1, byte(len(user))}
+		req = append(req, user...)
+		req = append(req, byte(len(pass)))
+		req = append(req, pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			return err
+		}
+		if buf[1] != socks5Succeeded {
+			return errors.New("nats: SOCKS proxy authentication failed")
+		}
+	case socks5NoAcceptable:
+		return errors.New("nats: no acceptable SOCKS authentication method")
+	default:
+		return fmt.Errorf("nats: unexpected SOCKS authentication method %v", buf[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("nats: host name %q too long", host)
+		}
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, then the bound address.
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[1] != socks5Succeeded {
+		return fmt.Errorf("nats: SOCKS proxy refused connection to %s: code %v", addr, hdr[1])
+	}
+	var n int
+	switch hdr[3] {
+	case socks5IPv4:
+		n = net.IPv4len
+	case socks5IPv6:
+		n = net.IPv6len
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return fmt.Errorf("nats: unexpected SOCKS address type %v", hdr[3])
+	}
+	// Skip the bound address and port.
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrReconnectNotAllowed = errors.New("nats: reconnect not allowed")
+	// ErrForcedReconnect is the error given to the disconnect callback
+	// when the connection is left to reconnect, as with Conn.Reconnect.
+	ErrForcedReconnect = errors.New("nats: reconnect requested")
+)
+
+// LameDuckModeReconnect is an Option to reconnect to another server of
+// the pool when the server notifies that it entered lame duck mode,
+// instead of waiting for the server to close the connection. The reconnect
+// happens after a random delay up to `jitter`, and like with Conn.Reconnect,
+// the buffered data is written out before leaving the server and the
+// subscriptions are re-sent before the data published in the meantime.
+// Nothing is done if the pool has no other server.
+func LameDuckModeReconnect(jitter time.Duration) Option {
+	return func(o *Options) error {
+		if jitter < 0 {
+			return ErrInvalidArg
+		}
+		o.LameDuckModeReconnect = true
+		o.LameDuckModeReconnectJitter = jitter
+		return nil
+	}
+}
+
+// Reconnect closes the connection to the current server and reconnects
+// to the next server of the pool, as if the connection had been lost:
+// the server is chosen by the ServerSelector, if set, and the reconnect
+// callbacks are invoked. The server we leave is tried last.
+// Subscriptions are re-sent after the reconnect, and messages published
+// in the meantime are buffered as with any reconnect. Pending Flush
+// calls fail, and requests may time out if their reply was in flight.
+//
+// Reconnect returns without waiting for the connection to be
+// re-established, see Conn.StatusChanged to wait for it.
+func (nc *Conn) Reconnect() error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if err := nc.canForceReconnect(); err != nil {
+		return err
+	}
+	nc.forceReconnect()
+	return nil
+}
+
+// SwitchServer is like Reconnect, but tries the server with the given
+// URL first, adding it to the pool if needed. If the server can not be
+// connected to, the other servers of the pool are tried as usual.
+func (nc *Conn) SwitchServer(url string) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if err := nc.canForceReconnect(); err != nil {
+		return err
+	}
+	n := len(nc.srvPool)
+	if err := nc.addURLToPool(url, false, false); err != nil {
+		return err
+	}
+	s := nc.srvPool[n]
+	for _, ps := range nc.srvPool[:n] {
+		if serverAddr(ps.url) == serverAddr(s.url) {
+			// Already in the pool, use the existing entry.
+			nc.srvPool = nc.srvPool[:n]
+			s = ps
+			break
+		}
+	}
+	nc.switchTo = s
+	nc.forceReconnect()
+	return nil
+}
+
+// Lock should be held.
+func (nc *Conn) canForceReconnect() error {
+	switch {
+	case nc.isClosed():
+		return ErrConnectionClosed
+	case nc.isDraining():
+		return ErrConnectionDraining
+	case nc.isConnecting() || nc.isReconnecting():
+		return ErrConnectionReconnecting
+	case !nc.Opts.AllowReconnect:
+		return ErrReconnectNotAllowed
+	}
+	return nil
+}
+
+// forceReconnect writes out the buffered data, then starts
+// the reconnect loop.
+// Lock should be held.
+func (nc *Conn) forceReconnect() {
+	nc.log(LogLevelInfo, "forcing reconnect", "server", nc.currentURL())
+	if nc.conn != nil {
+		nc.bw.flush()
+	}
+	nc.startReconnect(ErrForcedReconnect)
+}
+
+// scheduleLameDuckModeReconnect schedules the reconnect to another server
+// after the current one entered lame duck mode.
+// Lock should be held.
+func (nc *Conn) scheduleLameDuckModeReconnect() {
+	if nc.ldmTimer != nil || len(nc.srvPool) < 2 {
+		return
+	}
+	var delay time.Duration
+	if j := nc.Opts.LameDuckModeReconnectJitter; j > 0 {
+		delay = time.Duration(rand.Int63n(int64(j)))
+	}
+	cur := nc.current
+	nc.ldmTimer = time.AfterFunc(delay, func() {
+		nc.mu.Lock()
+		defer nc.mu.Unlock()
+		nc.ldmTimer = nil
+		// Nothing to do if we already left the server.
+		if nc.current != cur || nc.canForceReconnect() != nil {
+			return
+		}
+		nc.forceReconnect()
+	})
+}
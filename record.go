@@ -0,0 +1,328 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidCapture = errors.New("nats: invalid protocol capture")
+
+// CaptureDir is the kind of a capture record.
+type CaptureDir byte
+
+const (
+	// CaptureConnect starts the records of a new connection,
+	// the data is the URL of the server.
+	CaptureConnect CaptureDir = '+'
+	// CaptureSent holds data sent to the server.
+	CaptureSent CaptureDir = '>'
+	// CaptureReceived holds data received from the server.
+	CaptureReceived CaptureDir = '<'
+)
+
+// CaptureRecord is a chunk of protocol data of a capture.
+type CaptureRecord struct {
+	Time time.Time
+	Dir  CaptureDir
+	Data []byte
+}
+
+// ProtocolRecorder writes the raw protocol exchanged with the servers
+// to a capture, for debugging or to replay it later.
+//
+// Each record is written as a header line with the time, the direction
+// and the size of the data, followed by the data and a new line:
+//
+//	2022-06-01T10:00:00.000000001Z < 6
+//	PONG
+//
+// Passwords, tokens, JWTs and signatures are always removed from the
+// CONNECT protocol. With payload redaction, the message payloads are
+// replaced by 'x' characters, keeping their size; headers are kept.
+type ProtocolRecorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	redact bool
+	err    error
+}
+
+// NewProtocolRecorder returns a ProtocolRecorder writing to w.
+func NewProtocolRecorder(w io.Writer, redactPayloads bool) *ProtocolRecorder {
+	return &ProtocolRecorder{w: w, redact: redactPayloads}
+}
+
+// RecordProtocol is an Option to record the protocol exchanged with
+// the servers. A recorder may be shared by several connections.
+func RecordProtocol(rec *ProtocolRecorder) Option {
+	return func(o *Options) error {
+		o.ProtocolRecorder = rec
+		return nil
+	}
+}
+
+// Err returns the first error writing the capture, after which
+// nothing more is recorded.
+func (r *ProtocolRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *ProtocolRecorder) record(dir CaptureDir, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	hdr := fmt.Sprintf("%s %c %d\n", time.Now().UTC().Format(time.RFC3339Nano), dir, len(data))
+	if _, r.err = io.WriteString(r.w, hdr); r.err != nil {
+		return
+	}
+	if _, r.err = r.w.Write(data); r.err != nil {
+		return
+	}
+	_, r.err = r.w.Write([]byte{'\n'})
+}
+
+// recordConnect records the start of a new connection.
+// Lock should be held.
+func (nc *Conn) recordConnect() {
+	if rec := nc.Opts.ProtocolRecorder; rec != nil {
+		u := nc.current.url
+		rec.record(CaptureConnect, []byte(u.Scheme+"://"+serverAddr(u)))
+	}
+}
+
+// recordIO records the reads and writes of the connection, above the
+// websocket framing if any. Lock should be held.
+func (nc *Conn) recordIO() {
+	rec := nc.Opts.ProtocolRecorder
+	if rec == nil {
+		return
+	}
+	nc.br.r = &recordReader{r: nc.br.r, rec: rec, red: redactor{payloads: rec.redact}}
+	nc.bw.w = &recordWriter{w: nc.bw.w, rec: rec, red: redactor{payloads: rec.redact}}
+}
+
+type recordReader struct {
+	r   io.Reader
+	rec *ProtocolRecorder
+	red redactor
+}
+
+func (rr *recordReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if data := rr.red.redact(p[:n]); len(data) > 0 {
+		rr.rec.record(CaptureReceived, data)
+	}
+	return n, err
+}
+
+type recordWriter struct {
+	w   io.Writer
+	rec *ProtocolRecorder
+	red redactor
+}
+
+func (rw *recordWriter) Write(p []byte) (int, error) {
+	// The websocket writer masks the data in place, and reports
+	// the size of the frames written, so keep a copy.
+	data := append([]byte(nil), p...)
+	n, err := rw.w.Write(p)
+	if n > len(data) {
+		n = len(data)
+	}
+	if data = rw.red.redact(data[:n]); len(data) > 0 {
+		rw.rec.record(CaptureSent, data)
+	}
+	return n, err
+}
+
+// Fields of the CONNECT protocol removed from the captures.
+var redactedConnectFields = []string{"pass", "auth_token", "jwt", "sig"}
+
+// redactor follows the protocol in one direction to find the
+// payloads and credentials to redact.
+type redactor struct {
+	payloads bool
+	line     []byte // control line read so far
+	keep     int    // header bytes to keep
+	hide     int    // payload bytes to redact
+	tail     int    // CRLF after the payload
+}
+
+// redact returns a redacted copy of the data. Control lines are held
+// until complete, so that a CONNECT split in several chunks is redacted.
+func (rd *redactor) redact(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		n := len(b)
+		switch {
+		case rd.keep > 0:
+			if n > rd.keep {
+				n = rd.keep
+			}
+			out = append(out, b[:n]...)
+			rd.keep -= n
+		case rd.hide > 0:
+			if n > rd.hide {
+				n = rd.hide
+			}
+			for i := 0; i < n; i++ {
+				out = append(out, 'x')
+			}
+			rd.hide -= n
+		case rd.tail > 0:
+			if n > rd.tail {
+				n = rd.tail
+			}
+			out = append(out, b[:n]...)
+			rd.tail -= n
+		default:
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				rd.line = append(rd.line, b...)
+				return out
+			}
+			n = i + 1
+			rd.line = append(rd.line, b[:n]...)
+			if isConnectLine(rd.line) {
+				out = append(out, redactConnect(rd.line)...)
+			} else {
+				out = append(out, rd.line...)
+			}
+			rd.startPayload(rd.line)
+			rd.line = rd.line[:0]
+		}
+		b = b[n:]
+	}
+	return out
+}
+
+// startPayload sets the sizes of the payload following the control line.
+func (rd *redactor) startPayload(line []byte) {
+	hdr, total, ok := payloadSizes(line)
+	if !ok {
+		return
+	}
+	if rd.payloads {
+		rd.keep, rd.hide, rd.tail = hdr, total-hdr, len(_CRLF_)
+	} else {
+		rd.keep, rd.tail = total, len(_CRLF_)
+	}
+}
+
+// payloadSizes returns the header and total sizes of the payload
+// following a PUB, HPUB, MSG or HMSG control line.
+func payloadSizes(line []byte) (int, int, bool) {
+	args := strings.Fields(string(line))
+	if len(args) < 3 {
+		return 0, 0, false
+	}
+	var headers bool
+	switch strings.ToUpper(args[0]) {
+	case "PUB", "MSG":
+	case "HPUB", "HMSG":
+		headers = true
+	default:
+		return 0, 0, false
+	}
+	total, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || total < 0 {
+		return 0, 0, false
+	}
+	var hdr int
+	if headers {
+		if hdr, err = strconv.Atoi(args[len(args)-2]); err != nil || hdr < 0 || hdr > total {
+			return 0, 0, false
+		}
+	}
+	return hdr, total, true
+}
+
+func isConnectLine(line []byte) bool {
+	const op = "CONNECT "
+	return len(line) > len(op) && strings.EqualFold(string(line[:len(op)]), op)
+}
+
+// redactConnect removes the credentials from a CONNECT protocol line.
+func redactConnect(line []byte) []byte {
+	arg := bytes.TrimSpace(line[len("CONNECT "):])
+	var fields map[string]interface{}
+	if json.Unmarshal(arg, &fields) != nil {
+		return []byte("CONNECT {}" + _CRLF_)
+	}
+	for _, f := range redactedConnectFields {
+		if _, ok := fields[f]; ok {
+			fields[f] = "[REDACTED]"
+		}
+	}
+	b, _ := json.Marshal(fields)
+	return []byte("CONNECT " + string(b) + _CRLF_)
+}
+
+// CaptureReader reads the records of a capture
+// written by a ProtocolRecorder.
+type CaptureReader struct {
+	br *bufio.Reader
+}
+
+// NewCaptureReader returns a CaptureReader reading from r.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{br: bufio.NewReader(r)}
+}
+
+// Next returns the next record, or io.EOF at the end of the capture.
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	line, err := cr.br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != _EMPTY_ {
+			return nil, ErrInvalidCapture
+		}
+		return nil, err
+	}
+	args := strings.Fields(line)
+	if len(args) != 3 || len(args[1]) != 1 {
+		return nil, ErrInvalidCapture
+	}
+	t, err := time.Parse(time.RFC3339Nano, args[0])
+	if err != nil {
+		return nil, ErrInvalidCapture
+	}
+	dir := CaptureDir(args[1][0])
+	switch dir {
+	case CaptureConnect, CaptureSent, CaptureReceived:
+	default:
+		return nil, ErrInvalidCapture
+	}
+	n, err := strconv.Atoi(args[2])
+	if err != nil || n < 0 {
+		return nil, ErrInvalidCapture
+	}
+	data := make([]byte, n+1)
+	if _, err := io.ReadFull(cr.br, data); err != nil || data[n] != '\n' {
+		return nil, ErrInvalidCapture
+	}
+	return &CaptureRecord{Time: t, Dir: dir, Data: data[:n]}, nil
+}
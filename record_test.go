@@ -0,0 +1,57 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestRecordRedactSplitConnect(t *testing.T) {
+	var capture bytes.Buffer
+	rec := NewProtocolRecorder(&capture, false)
+	w := &recordWriter{w: io.Discard, rec: rec}
+
+	proto := []byte("CONNECT {\"user\":\"derek\",\"pass\":\"secretpwd\",\"jwt\":\"eyJ0eXAi\"}\r\nPING\r\n")
+	for _, chunk := range [][]byte{proto[:3], proto[3:30], proto[30:]} {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("Error on write: %v", err)
+		}
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+
+	var sent []byte
+	cr := NewCaptureReader(&capture)
+	for {
+		r, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading capture: %v", err)
+		}
+		sent = append(sent, r.Data...)
+	}
+	for _, secret := range []string{"secretpwd", "eyJ0eXAi"} {
+		if bytes.Contains(sent, []byte(secret)) {
+			t.Fatalf("Secret %q was recorded: %q", secret, sent)
+		}
+	}
+	if !bytes.Contains(sent, []byte("\"user\":\"derek\"")) || !bytes.HasSuffix(sent, []byte("\r\nPING\r\n")) {
+		t.Fatalf("Unexpected capture: %q", sent)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"context"
+	"time"
+)
+
+// RequestManyChanLen is the size of the channel returned by RequestMany.
+// Responses received while the channel and the internal buffer are full
+// are dropped.
+const RequestManyChanLen = 256
+
+// RequestManyOpt configures a RequestMany call.
+type RequestManyOpt func(*requestManyOpts) error
+
+type requestManyOpts struct {
+	maxMsgs  int
+	maxWait  time.Duration
+	stall    time.Duration
+	sentinel func(*Msg) bool
+}
+
+// RequestManyMaxMessages stops collecting responses after `n` responses.
+func RequestManyMaxMessages(n int) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if n <= 0 {
+			return ErrInvalidArg
+		}
+		o.maxMsgs = n
+		return nil
+	}
+}
+
+// RequestManyMaxWait sets the overall time spent collecting responses.
+// Defaults to the connection's Timeout option.
+func RequestManyMaxWait(d time.Duration) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if d <= 0 {
+			return ErrInvalidArg
+		}
+		o.maxWait = d
+		return nil
+	}
+}
+
+// RequestManyStall stops collecting responses when no response was
+// received within the given interval after the previous one. The first
+// response is only bound by the overall timeout.
+func RequestManyStall(d time.Duration) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if d <= 0 {
+			return ErrInvalidArg
+		}
+		o.stall = d
+		return nil
+	}
+}
+
+// RequestManySentinel stops collecting responses when `f` returns true for
+// a response. The sentinel response is not delivered. See EmptySentinel.
+func RequestManySentinel(f func(*Msg) bool) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if f == nil {
+			return ErrInvalidArg
+		}
+		o.sentinel = f
+		return nil
+	}
+}
+
+// EmptySentinel is a sentinel for RequestManySentinel that
+// matches a response without headers nor payload.
+func EmptySentinel(m *Msg) bool {
+	return len(m.Data) == 0 && len(m.Header) == 0
+}
+
+// RequestMany sends a request and returns a channel on which all the
+// responses are delivered, until the maximum number of messages, the
+// overall timeout, the stall interval or the sentinel, if configured,
+// terminates the request. The channel is then closed.
+// The responses are received through the connection's shared response
+// subscription, as for Request.
+// A no responders status terminates the request without delivering
+// any message.
+func (nc *Conn) RequestMany(subj string, data []byte, opts ...RequestManyOpt) (<-chan *Msg, error) {
+	return nc.requestMany(nil, subj, nil, data, opts)
+}
+
+// RequestManyMsg is like RequestMany but takes a message,
+// which may include headers.
+func (nc *Conn) RequestManyMsg(msg *Msg, opts ...RequestManyOpt) (<-chan *Msg, error) {
+	if msg == nil {
+		return nil, ErrInvalidMsg
+	}
+	hdr, err := nc.requestHeaderBytes(msg)
+	if err != nil {
+		return nil, err
+	}
+	return nc.requestMany(nil, msg.Subject, hdr, msg.Data, opts)
+}
+
+// RequestManyWithContext is like RequestMany, but the request is also
+// terminated when the context is done. The overall timeout only applies
+// if set with RequestManyMaxWait.
+func (nc *Conn) RequestManyWithContext(ctx context.Context, subj string, data []byte, opts ...RequestManyOpt) (<-chan *Msg, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	return nc.requestMany(ctx, subj, nil, data, opts)
+}
+
+func (nc *Conn) requestHeaderBytes(msg *Msg) ([]byte, error) {
+	if len(msg.Header) == 0 {
+		return nil, nil
+	}
+	if !nc.info.Headers {
+		return nil, ErrHeadersNotSupported
+	}
+	return msg.headerBytes()
+}
+
+func (nc *Conn) requestMany(ctx context.Context, subj string, hdr, data []byte, opts []RequestManyOpt) (<-chan *Msg, error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	var o requestManyOpts
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if ctx != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if o.maxWait == 0 && ctx == nil {
+		nc.mu.RLock()
+		o.maxWait = nc.Opts.Timeout
+		nc.mu.RUnlock()
+	}
+
+	mch := make(chan *Msg, RequestManyChanLen)
+	respInbox, token, err := nc.registerRespChan(mch, true)
+	if err != nil {
+		nc.removeRespChan(token)
+		return nil, err
+	}
+	if err := nc.publishWithContext(ctx, subj, respInbox, hdr, data); err != nil {
+		nc.removeRespChan(token)
+		return nil, err
+	}
+
+	out := make(chan *Msg, RequestManyChanLen)
+	go nc.collectResponses(ctx, token, mch, out, o)
+	return out, nil
+}
+
+// removeRespChan removes the mapping of a response token.
+func (nc *Conn) removeRespChan(token string) {
+	nc.mu.Lock()
+	delete(nc.respMap, token)
+	delete(nc.respMany, token)
+	nc.mu.Unlock()
+}
+
+// collectResponses forwards the responses received on `mch` to `out`
+// until the request terminates.
+func (nc *Conn) collectResponses(ctx context.Context, token string, mch chan *Msg, out chan *Msg, o requestManyOpts) {
+	defer close(out)
+	defer nc.removeRespChan(token)
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	var deadline <-chan time.Time
+	if o.maxWait > 0 {
+		t := time.NewTimer(o.maxWait)
+		defer t.Stop()
+		deadline = t.C
+	}
+	var stall <-chan time.Time
+	var st *time.Timer
+	defer func() {
+		if st != nil {
+			st.Stop()
+		}
+	}()
+
+	for count := 0; o.maxMsgs == 0 || count < o.maxMsgs; count++ {
+		var m *Msg
+		var ok bool
+		select {
+		case m, ok = <-mch:
+			if !ok {
+				// Connection closed.
+				return
+			}
+		case <-deadline:
+			return
+		case <-stall:
+			return
+		case <-done:
+			return
+		}
+		if count == 0 && len(m.Data) == 0 && m.Header.Get(statusHdr) == noResponders {
+			return
+		}
+		if o.sentinel != nil && o.sentinel(m) {
+			return
+		}
+		select {
+		case out <- m:
+		case <-deadline:
+			return
+		case <-done:
+			return
+		}
+		if o.stall > 0 {
+			if st == nil {
+				st = time.NewTimer(o.stall)
+				stall = st.C
+			} else {
+				if !st.Stop() {
+					select {
+					case <-st.C:
+					default:
+					}
+				}
+				st.Reset(o.stall)
+			}
+		}
+	}
+}
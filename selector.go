@@ -0,0 +1,243 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// PoolServer describes a server of the pool to a ServerSelector.
+type PoolServer struct {
+	URL *url.URL
+
+	// Discovered is true if the server was discovered through
+	// the cluster, rather than configured in the options.
+	Discovered bool
+
+	// DidConnect is true if a connection was established to this server.
+	DidConnect bool
+
+	// Failed is true if the last connection attempt to this server failed.
+	Failed bool
+
+	// Reconnects is the number of reconnection attempts to this
+	// server since the last successful connection.
+	Reconnects int
+
+	// RTT is the round trip time measured during the last connection
+	// handshake with this server, or zero if never connected.
+	RTT time.Duration
+
+	// Name, Cluster and Tags are taken from the INFO received during the
+	// last connection to this server, and are empty if never connected.
+	Name    string
+	Cluster string
+	Tags    []string
+}
+
+// ServerSelector selects the server to connect, or reconnect, to.
+type ServerSelector interface {
+	// Select returns the index of the server to try next among the given
+	// servers, which are listed in pool order. When reconnecting, the server
+	// that the connection just lost, or failed to connect to, is not listed
+	// unless it is the only one in the pool. An out of range index selects
+	// the first server. Select is not invoked if there is a single server
+	// to choose from.
+	// Select is invoked with the connection's lock held and must not
+	// invoke the connection's methods.
+	Select(servers []PoolServer) int
+}
+
+// ServerSelectorFunc is an adapter to use a function as a ServerSelector.
+type ServerSelectorFunc func(servers []PoolServer) int
+
+// Select invokes f(servers).
+func (f ServerSelectorFunc) Select(servers []PoolServer) int {
+	return f(servers)
+}
+
+// SetServerSelector is an Option to set the server selection strategy.
+func SetServerSelector(selector ServerSelector) Option {
+	return func(o *Options) error {
+		o.ServerSelector = selector
+		return nil
+	}
+}
+
+// DiscoveredServersFilter is an Option to set a filter for the servers
+// discovered through the cluster. Servers for which the filter returns
+// false are not added to the server pool, and do not trigger the
+// DiscoveredServersCB.
+func DiscoveredServersFilter(filter func(url string) bool) Option {
+	return func(o *Options) error {
+		o.DiscoveredServersFilter = filter
+		return nil
+	}
+}
+
+// selectServer moves the server selected among nc.srvPool[offset:end]
+// to position `offset`, keeping the order of the other servers.
+// Lock should be held.
+func (nc *Conn) selectServer(offset, end int) {
+	if end-offset < 2 {
+		return
+	}
+	servers := make([]PoolServer, 0, end-offset)
+	for _, s := range nc.srvPool[offset:end] {
+		servers = append(servers, PoolServer{
+			URL:        s.url,
+			Discovered: s.isImplicit,
+			DidConnect: s.didConnect,
+			Failed:     s.failed,
+			Reconnects: s.reconnects,
+			RTT:        s.rtt,
+			Name:       s.name,
+			Cluster:    s.cluster,
+			Tags:       s.tags,
+		})
+	}
+	i := nc.Opts.ServerSelector.Select(servers)
+	if i <= 0 || i >= len(servers) {
+		return
+	}
+	s := nc.srvPool[offset+i]
+	copy(nc.srvPool[offset+1:offset+i+1], nc.srvPool[offset:offset+i])
+	nc.srvPool[offset] = s
+}
+
+// selectBest returns the index of the best server according to `less`,
+// preferring servers whose last connection attempt did not fail.
+// Ties are resolved in pool order.
+func selectBest(servers []PoolServer, less func(a, b *PoolServer) bool) int {
+	best := 0
+	for i := 1; i < len(servers); i++ {
+		a, b := &servers[i], &servers[best]
+		if a.Failed != b.Failed {
+			if !a.Failed {
+				best = i
+			}
+			continue
+		}
+		if less(a, b) {
+			best = i
+		}
+	}
+	return best
+}
+
+type randomSelector struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// RandomSelector returns a ServerSelector selecting a random server among
+// the ones whose last connection attempt did not fail, if any.
+// This is similar to the default randomization of the server pool.
+func RandomSelector() ServerSelector {
+	return &randomSelector{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (rs *randomSelector) Select(servers []PoolServer) int {
+	candidates := make([]int, 0, len(servers))
+	for i := range servers {
+		if !servers[i].Failed {
+			candidates = append(candidates, i)
+		}
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(candidates) == 0 {
+		return rs.r.Intn(len(servers))
+	}
+	return candidates[rs.r.Intn(len(candidates))]
+}
+
+// RTTSelector returns a ServerSelector selecting the server with the lowest
+// round trip time measured during the last connection to each server.
+// Servers never connected to come after the ones with a known round trip
+// time, and servers whose last connection attempt failed come last.
+func RTTSelector() ServerSelector {
+	return ServerSelectorFunc(func(servers []PoolServer) int {
+		return selectBest(servers, func(a, b *PoolServer) bool {
+			if a.RTT == 0 || b.RTT == 0 {
+				return b.RTT == 0 && a.RTT != 0
+			}
+			return a.RTT < b.RTT
+		})
+	})
+}
+
+// LocalitySelector returns a ServerSelector preferring servers in the
+// given cluster, if not empty, and having all the given tags, as reported
+// in the INFO received during the last connection to each server.
+// Servers never connected to, whose locality is unknown, come after the
+// local ones but before the remote ones. Servers whose last connection
+// attempt failed come last.
+func LocalitySelector(cluster string, tags ...string) ServerSelector {
+	// Lower is better.
+	rank := func(s *PoolServer) int {
+		if !s.DidConnect {
+			return 1
+		}
+		if cluster != _EMPTY_ && s.Cluster != cluster {
+			return 2
+		}
+		for _, t := range tags {
+			if !hasTag(s.Tags, t) {
+				return 2
+			}
+		}
+		return 0
+	}
+	return ServerSelectorFunc(func(servers []PoolServer) int {
+		return selectBest(servers, func(a, b *PoolServer) bool {
+			return rank(a) < rank(b)
+		})
+	})
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsistentHashSelector returns a ServerSelector selecting servers in an
+// order determined by the hash of `key`, typically the connection name,
+// and of each server's address (rendezvous hashing). Clients using the
+// same key prefer the same server, while clients with different keys are
+// spread over the pool. Servers joining or leaving the pool only affect
+// the clients that prefer them. Servers whose last connection attempt
+// failed come last.
+func ConsistentHashSelector(key string) ServerSelector {
+	weight := func(s *PoolServer) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(s.URL.Host))
+		return h.Sum64()
+	}
+	return ServerSelectorFunc(func(servers []PoolServer) int {
+		return selectBest(servers, func(a, b *PoolServer) bool {
+			return weight(a) > weight(b)
+		})
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DefaultDiscoveryTimeout is the maximum time spent collecting
+	// responses to a discovery request.
+	DefaultDiscoveryTimeout = time.Second
+
+	// DefaultDiscoveryStall is the time after which the collection stops
+	// if no new response was received since the last one.
+	DefaultDiscoveryStall = 100 * time.Millisecond
+)
+
+// Client discovers services and collects their information and statistics
+// using scatter-gather requests on the discovery subjects.
+type Client struct {
+	nc *nats.Conn
+
+	// Timeout is the maximum time spent collecting responses.
+	Timeout time.Duration
+	// Stall, if positive, stops the collection when no response was
+	// received within that interval after the last response.
+	Stall time.Duration
+}
+
+// NewClient returns a discovery client using the default timeouts.
+func NewClient(nc *nats.Conn) *Client {
+	return &Client{nc: nc, Timeout: DefaultDiscoveryTimeout, Stall: DefaultDiscoveryStall}
+}
+
+// Ping returns the responses of the running instances of the named
+// service, or of all services if name is empty.
+func (c *Client) Ping(name string) ([]Ping, error) {
+	var resps []Ping
+	err := c.gather(ControlSubject(PingVerb, name, ""), func(data []byte) error {
+		var p Ping
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		resps = append(resps, p)
+		return nil
+	})
+	return resps, err
+}
+
+// Info returns the description of the running instances of the named
+// service, or of all services if name is empty.
+func (c *Client) Info(name string) ([]Info, error) {
+	var resps []Info
+	err := c.gather(ControlSubject(InfoVerb, name, ""), func(data []byte) error {
+		var i Info
+		if err := json.Unmarshal(data, &i); err != nil {
+			return err
+		}
+		resps = append(resps, i)
+		return nil
+	})
+	return resps, err
+}
+
+// Stats returns the statistics of the running instances of the named
+// service, or of all services if name is empty.
+func (c *Client) Stats(name string) ([]Stats, error) {
+	var resps []Stats
+	err := c.gather(ControlSubject(StatsVerb, name, ""), func(data []byte) error {
+		var s Stats
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		resps = append(resps, s)
+		return nil
+	})
+	return resps, err
+}
+
+// gather sends a request on the subject and invokes fn for each
+// response received until the timeout, or stall, expires.
+func (c *Client) gather(subj string, fn func(data []byte) error) error {
+	opts := []nats.RequestManyOpt{nats.RequestManyMaxWait(c.Timeout)}
+	if c.Stall > 0 {
+		opts = append(opts, nats.RequestManyStall(c.Stall))
+	}
+	resps, err := c.nc.RequestMany(subj, nil, opts...)
+	if err != nil {
+		return err
+	}
+	for m := range resps {
+		if err := fn(m.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServiceStats are the statistics of a service, aggregated over
+// all its running instances.
+type ServiceStats struct {
+	Name      string
+	Instances int
+	Endpoints []EndpointStats
+}
+
+// AggregateStats aggregates the statistics of the instances of each
+// service, per endpoint name. The result is sorted by service name.
+func AggregateStats(stats []Stats) []ServiceStats {
+	byName := make(map[string]*ServiceStats)
+	eps := make(map[string]map[string]*EndpointStats)
+	for _, s := range stats {
+		ss, ok := byName[s.Name]
+		if !ok {
+			ss = &ServiceStats{Name: s.Name}
+			byName[s.Name] = ss
+			eps[s.Name] = make(map[string]*EndpointStats)
+		}
+		ss.Instances++
+		for _, e := range s.Endpoints {
+			agg, ok := eps[s.Name][e.Name]
+			if !ok {
+				agg = &EndpointStats{Name: e.Name, Subject: e.Subject}
+				eps[s.Name][e.Name] = agg
+			}
+			agg.NumRequests += e.NumRequests
+			agg.NumErrors += e.NumErrors
+			agg.ProcessingTime += e.ProcessingTime
+			if e.LastError != "" {
+				agg.LastError = e.LastError
+			}
+		}
+	}
+	res := make([]ServiceStats, 0, len(byName))
+	for name, ss := range byName {
+		for _, e := range eps[name] {
+			if e.NumRequests > 0 {
+				e.AverageProcessingTime = e.ProcessingTime / time.Duration(e.NumRequests)
+			}
+			ss.Endpoints = append(ss.Endpoints, *e)
+		}
+		sort.Slice(ss.Endpoints, func(i, j int) bool { return ss.Endpoints[i].Name < ss.Endpoints[j].Name })
+		res = append(res, *ss)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
@@ -0,0 +1,446 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package services provides a lightweight framework to build services
+// on top of NATS request/reply. A service is made of endpoints, each bound
+// to a queue subscription, and automatically answers discovery requests
+// on the well-known `$SRV.PING`, `$SRV.INFO` and `$SRV.STATS` subjects.
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/subject"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// APIPrefix is the root of the discovery subjects.
+	APIPrefix = "$SRV"
+
+	// DefaultQueueGroup is the queue group used by endpoints
+	// when none is configured.
+	DefaultQueueGroup = "q"
+
+	// ErrorHeader and ErrorCodeHeader are set on error replies.
+	ErrorHeader     = "Nats-Service-Error"
+	ErrorCodeHeader = "Nats-Service-Error-Code"
+)
+
+// Verb is a discovery request type.
+type Verb int
+
+const (
+	PingVerb Verb = iota
+	InfoVerb
+	StatsVerb
+)
+
+func (v Verb) String() string {
+	switch v {
+	case PingVerb:
+		return "PING"
+	case InfoVerb:
+		return "INFO"
+	case StatsVerb:
+		return "STATS"
+	}
+	return "unknown verb"
+}
+
+// Types set in the `type` field of the discovery responses.
+const (
+	PingResponseType  = "io.nats.services.v1.ping_response"
+	InfoResponseType  = "io.nats.services.v1.info_response"
+	StatsResponseType = "io.nats.services.v1.stats_response"
+)
+
+var (
+	ErrConfigValidation = errors.New("services: invalid service configuration")
+	ErrServiceStopped   = errors.New("services: service stopped")
+	ErrRespondTwice     = errors.New("services: request already responded")
+)
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9\-_]+$`)
+
+// Handler processes a request received on an endpoint.
+type Handler func(*Request)
+
+// Endpoint describes an endpoint of the service.
+type Endpoint struct {
+	// Name of the endpoint, used in the stats.
+	Name string
+	// Subject on which the endpoint listens, which may contain
+	// wildcards. Defaults to the name.
+	Subject string
+	// Handler invoked for every request.
+	Handler Handler
+}
+
+// Config is the configuration of a service.
+type Config struct {
+	// Name of the service, made of alphanumerical characters,
+	// dashes and underscores.
+	Name string
+	// Version of the service, in semantic versioning format.
+	Version string
+	// Description is an optional description of the service.
+	Description string
+	// QueueGroup used by the endpoints, DefaultQueueGroup if empty.
+	QueueGroup string
+	// Endpoints of the service.
+	Endpoints []Endpoint
+}
+
+// Ping is the response to a PING discovery request.
+type Ping struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+// Info is the response to an INFO discovery request.
+type Info struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name"`
+	ID          string         `json:"id"`
+	Version     string         `json:"version"`
+	Description string         `json:"description,omitempty"`
+	Endpoints   []EndpointInfo `json:"endpoints"`
+}
+
+// EndpointInfo describes an endpoint in the INFO response.
+type EndpointInfo struct {
+	Name       string `json:"name"`
+	Subject    string `json:"subject"`
+	QueueGroup string `json:"queue_group"`
+}
+
+// Stats is the response to a STATS discovery request.
+type Stats struct {
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	ID        string          `json:"id"`
+	Version   string          `json:"version"`
+	Started   time.Time       `json:"started"`
+	Endpoints []EndpointStats `json:"endpoints"`
+}
+
+// EndpointStats are the statistics of an endpoint.
+type EndpointStats struct {
+	Name                  string        `json:"name"`
+	Subject               string        `json:"subject"`
+	NumRequests           uint64        `json:"num_requests"`
+	NumErrors             uint64        `json:"num_errors"`
+	LastError             string        `json:"last_error,omitempty"`
+	ProcessingTime        time.Duration `json:"processing_time"`
+	AverageProcessingTime time.Duration `json:"average_processing_time"`
+}
+
+// Service is a running service, created with AddService.
+type Service struct {
+	mu        sync.Mutex
+	nc        *nats.Conn
+	cfg       Config
+	id        string
+	started   time.Time
+	endpoints []*endpoint
+	subs      []*nats.Subscription
+	stopped   bool
+}
+
+type endpoint struct {
+	svc        *Service
+	name       string
+	subject    string
+	handler    Handler
+	numReqs    uint64
+	numErrs    uint64
+	lastErr    string
+	processing time.Duration
+}
+
+// AddService validates the configuration, starts the endpoints
+// on queue subscriptions and subscribes to the discovery subjects.
+func AddService(nc *nats.Conn, cfg Config) (*Service, error) {
+	if nc == nil {
+		return nil, nats.ErrInvalidConnection
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.QueueGroup == "" {
+		cfg.QueueGroup = DefaultQueueGroup
+	}
+	s := &Service{nc: nc, cfg: cfg, id: nuid.Next(), started: time.Now().UTC()}
+	for _, e := range cfg.Endpoints {
+		ep := &endpoint{svc: s, name: e.Name, subject: e.Subject, handler: e.Handler}
+		if ep.subject == "" {
+			ep.subject = e.Name
+		}
+		sub, err := nc.QueueSubscribe(ep.subject, cfg.QueueGroup, ep.handle)
+		if err != nil {
+			s.Stop()
+			return nil, err
+		}
+		s.endpoints = append(s.endpoints, ep)
+		s.subs = append(s.subs, sub)
+	}
+	for _, verb := range []Verb{PingVerb, InfoVerb, StatsVerb} {
+		verb := verb
+		for _, subj := range []string{
+			ControlSubject(verb, "", ""),
+			ControlSubject(verb, cfg.Name, ""),
+			ControlSubject(verb, cfg.Name, s.id),
+		} {
+			sub, err := nc.Subscribe(subj, func(m *nats.Msg) { s.handleDiscovery(verb, m) })
+			if err != nil {
+				s.Stop()
+				return nil, err
+			}
+			s.subs = append(s.subs, sub)
+		}
+	}
+	return s, nil
+}
+
+func (cfg *Config) validate() error {
+	if !nameRegexp.MatchString(cfg.Name) {
+		return fmt.Errorf("%w: invalid name %q", ErrConfigValidation, cfg.Name)
+	}
+	if cfg.Version == "" {
+		return fmt.Errorf("%w: version is required", ErrConfigValidation)
+	}
+	if len(cfg.Endpoints) == 0 {
+		return fmt.Errorf("%w: at least one endpoint is required", ErrConfigValidation)
+	}
+	names := make(map[string]struct{}, len(cfg.Endpoints))
+	for _, e := range cfg.Endpoints {
+		if !nameRegexp.MatchString(e.Name) {
+			return fmt.Errorf("%w: invalid endpoint name %q", ErrConfigValidation, e.Name)
+		}
+		if _, dup := names[e.Name]; dup {
+			return fmt.Errorf("%w: duplicate endpoint name %q", ErrConfigValidation, e.Name)
+		}
+		names[e.Name] = struct{}{}
+		if e.Subject != "" && !subject.IsValidFilter(e.Subject) {
+			return fmt.Errorf("%w: invalid subject %q for endpoint %q", ErrConfigValidation, e.Subject, e.Name)
+		}
+		if e.Handler == nil {
+			return fmt.Errorf("%w: endpoint %q has no handler", ErrConfigValidation, e.Name)
+		}
+	}
+	return nil
+}
+
+// ControlSubject returns the discovery subject for the given verb. If name
+// is empty, the subject addresses all services, and if id is empty, all
+// the instances of the named service.
+func ControlSubject(verb Verb, name, id string) string {
+	subj := APIPrefix + "." + verb.String()
+	if name == "" {
+		return subj
+	}
+	subj += "." + name
+	if id == "" {
+		return subj
+	}
+	return subj + "." + id
+}
+
+// ID returns the unique identifier of this service instance.
+func (s *Service) ID() string {
+	return s.id
+}
+
+// Info returns the description of the service.
+func (s *Service) Info() Info {
+	info := Info{
+		Type:        InfoResponseType,
+		Name:        s.cfg.Name,
+		ID:          s.id,
+		Version:     s.cfg.Version,
+		Description: s.cfg.Description,
+		Endpoints:   make([]EndpointInfo, 0, len(s.cfg.Endpoints)),
+	}
+	s.mu.Lock()
+	for _, ep := range s.endpoints {
+		info.Endpoints = append(info.Endpoints, EndpointInfo{Name: ep.name, Subject: ep.subject, QueueGroup: s.cfg.QueueGroup})
+	}
+	s.mu.Unlock()
+	return info
+}
+
+// Stats returns the statistics of the service's endpoints. Requests are
+// counted once responded to, with their processing time measured from
+// their reception to the response.
+func (s *Service) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{
+		Type:      StatsResponseType,
+		Name:      s.cfg.Name,
+		ID:        s.id,
+		Version:   s.cfg.Version,
+		Started:   s.started,
+		Endpoints: make([]EndpointStats, 0, len(s.endpoints)),
+	}
+	for _, ep := range s.endpoints {
+		es := EndpointStats{
+			Name:           ep.name,
+			Subject:        ep.subject,
+			NumRequests:    ep.numReqs,
+			NumErrors:      ep.numErrs,
+			LastError:      ep.lastErr,
+			ProcessingTime: ep.processing,
+		}
+		if ep.numReqs > 0 {
+			es.AverageProcessingTime = ep.processing / time.Duration(ep.numReqs)
+		}
+		stats.Endpoints = append(stats.Endpoints, es)
+	}
+	return stats
+}
+
+// Reset resets the statistics of the service's endpoints.
+func (s *Service) Reset() {
+	s.mu.Lock()
+	for _, ep := range s.endpoints {
+		ep.numReqs, ep.numErrs, ep.lastErr, ep.processing = 0, 0, "", 0
+	}
+	s.mu.Unlock()
+}
+
+// Stop drains the endpoints subscriptions and stops answering
+// discovery requests.
+func (s *Service) Stop() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrServiceStopped
+	}
+	s.stopped = true
+	subs := s.subs
+	s.subs = nil
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, sub := range subs {
+		if err := sub.Drain(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stopped returns true if the service has been stopped.
+func (s *Service) Stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+func (s *Service) handleDiscovery(verb Verb, m *nats.Msg) {
+	var resp interface{}
+	switch verb {
+	case PingVerb:
+		resp = Ping{Type: PingResponseType, Name: s.cfg.Name, ID: s.id, Version: s.cfg.Version}
+	case InfoVerb:
+		resp = s.Info()
+	case StatsVerb:
+		resp = s.Stats()
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	m.Respond(b)
+}
+
+func (ep *endpoint) handle(m *nats.Msg) {
+	ep.handler(&Request{Msg: m, ep: ep, start: time.Now()})
+}
+
+// record counts a responded request in the statistics, along with the
+// error set in the reply if not empty.
+func (ep *endpoint) record(elapsed time.Duration, lastErr string) {
+	s := ep.svc
+	s.mu.Lock()
+	ep.numReqs++
+	ep.processing += elapsed
+	if lastErr != "" {
+		ep.numErrs++
+		ep.lastErr = lastErr
+	}
+	s.mu.Unlock()
+}
+
+// Request is a request received on an endpoint. It may be responded to
+// after the handler returns, from another goroutine, but only once.
+type Request struct {
+	*nats.Msg
+	ep        *endpoint
+	start     time.Time
+	responded bool
+}
+
+// Respond sends the data as the reply to the request.
+func (r *Request) Respond(data []byte) error {
+	if r.responded {
+		return ErrRespondTwice
+	}
+	r.responded = true
+	r.record("")
+	return r.Msg.Respond(data)
+}
+
+// record counts the request in the statistics of its endpoint, if any.
+func (r *Request) record(lastErr string) {
+	if r.ep != nil {
+		r.ep.record(time.Since(r.start), lastErr)
+	}
+}
+
+// RespondJSON sends the JSON encoding of v as the reply to the request.
+func (r *Request) RespondJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Respond(b)
+}
+
+// Error sends an error reply, with the code and description set in the
+// ErrorCodeHeader and ErrorHeader headers, and counts it in the endpoint
+// statistics.
+func (r *Request) Error(code int, description string, data []byte) error {
+	if r.responded {
+		return ErrRespondTwice
+	}
+	r.responded = true
+	errCode := strconv.Itoa(code)
+	r.record(errCode + ":" + description)
+	msg := nats.NewMsg(r.Reply)
+	msg.Header.Set(ErrorCodeHeader, errCode)
+	msg.Header.Set(ErrorHeader, description)
+	msg.Data = data
+	return r.Msg.RespondMsg(msg)
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/natstest"
+)
+
+func TestRequestError(t *testing.T) {
+	s, err := natstest.NewServer(nil)
+	if err != nil {
+		t.Fatalf("Error starting server: %v", err)
+	}
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// Requests responded to after the handler returns are counted too.
+	svc, err := AddService(nc, Config{Name: "svc", Version: "1.0.0", Endpoints: []Endpoint{
+		{Name: "fail", Subject: "svc.fail.*", Handler: func(r *Request) {
+			go func() {
+				r.Error(503, "unavailable: try again", []byte("details"))
+				if err := r.Error(500, "twice", nil); err != ErrRespondTwice {
+					t.Errorf("Expected %v, got %v", ErrRespondTwice, err)
+				}
+			}()
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("Error adding service: %v", err)
+	}
+	defer svc.Stop()
+
+	resp, err := nc.Request("svc.fail.1", nil, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if code := resp.Header.Get(ErrorCodeHeader); code != "503" {
+		t.Fatalf("Unexpected error code: %q", code)
+	}
+	if descr := resp.Header.Get(ErrorHeader); descr != "unavailable: try again" {
+		t.Fatalf("Unexpected error description: %q", descr)
+	}
+	if string(resp.Data) != "details" {
+		t.Fatalf("Unexpected data: %q", resp.Data)
+	}
+
+	es := svc.Stats().Endpoints[0]
+	if es.NumRequests != 1 || es.NumErrors != 1 || es.LastError != "503:unavailable: try again" {
+		t.Fatalf("Unexpected stats: %+v", es)
+	}
+}
+
+func TestAggregateStats(t *testing.T) {
+	stats := []Stats{
+		{Name: "b", ID: "1", Endpoints: []EndpointStats{
+			{Name: "y", NumRequests: 1, ProcessingTime: time.Second},
+			{Name: "x", NumRequests: 2, NumErrors: 1, LastError: "500:first", ProcessingTime: 2 * time.Second},
+		}},
+		{Name: "a", ID: "2", Endpoints: []EndpointStats{
+			{Name: "x", Subject: "a.x"},
+		}},
+		{Name: "b", ID: "3", Endpoints: []EndpointStats{
+			{Name: "x", NumRequests: 2, NumErrors: 1, LastError: "500:second", ProcessingTime: 6 * time.Second},
+		}},
+	}
+	expected := []ServiceStats{
+		{Name: "a", Instances: 1, Endpoints: []EndpointStats{
+			{Name: "x", Subject: "a.x"},
+		}},
+		{Name: "b", Instances: 2, Endpoints: []EndpointStats{
+			{Name: "x", NumRequests: 4, NumErrors: 2, LastError: "500:second", ProcessingTime: 8 * time.Second, AverageProcessingTime: 2 * time.Second},
+			{Name: "y", NumRequests: 1, ProcessingTime: time.Second, AverageProcessingTime: time.Second},
+		}},
+	}
+	if got := AggregateStats(stats); fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, got)
+	}
+	if got := AggregateStats(nil); len(got) != 0 {
+		t.Fatalf("Expected no stats, got %+v", got)
+	}
+}
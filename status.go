@@ -0,0 +1,237 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import "time"
+
+// statusChanLen is the buffer size of the channels returned by
+// StatusChanged and Events.
+const statusChanLen = 32
+
+// ConnEventType is the type of a connection event.
+type ConnEventType int
+
+const (
+	// ConnectedEvent is emitted when the initial connection, retried in
+	// the background because of RetryOnFailedConnect, succeeds.
+	ConnectedEvent ConnEventType = iota
+	// DisconnectedEvent is emitted when the connection to the server is
+	// lost. The event's status is RECONNECTING if the library is going to
+	// reconnect, DISCONNECTED or CLOSED otherwise.
+	DisconnectedEvent
+	// ReconnectedEvent is emitted when the connection is re-established.
+	ReconnectedEvent
+	// ClosedEvent is emitted when the connection is closed. It is the
+	// last event, after which the listeners' channels are closed.
+	ClosedEvent
+	// LameDuckModeEvent is emitted when the server we are connected to
+	// enters lame duck mode.
+	LameDuckModeEvent
+	// DiscoveredServersEvent is emitted when new servers are discovered.
+	DiscoveredServersEvent
+	// DrainingEvent is emitted when the connection starts draining its
+	// subscriptions, then its publishers.
+	DrainingEvent
+)
+
+func (t ConnEventType) String() string {
+	switch t {
+	case ConnectedEvent:
+		return "CONNECTED"
+	case DisconnectedEvent:
+		return "DISCONNECTED"
+	case ReconnectedEvent:
+		return "RECONNECTED"
+	case ClosedEvent:
+		return "CLOSED"
+	case LameDuckModeEvent:
+		return "LAME_DUCK_MODE"
+	case DiscoveredServersEvent:
+		return "DISCOVERED_SERVERS"
+	case DrainingEvent:
+		return "DRAINING"
+	default:
+		return "unknown event type"
+	}
+}
+
+// ConnEvent describes a change in the state of a connection.
+type ConnEvent struct {
+	Type ConnEventType
+	// Status is the connection status after the event.
+	Status Status
+	// Server is the URL of the server the event relates to, if any.
+	Server string
+	// Err is the error that caused the event, if any.
+	Err  error
+	Time time.Time
+}
+
+type statusListener struct {
+	ch       chan Status
+	statuses []Status
+}
+
+type eventListener struct {
+	ch    chan ConnEvent
+	types []ConnEventType
+}
+
+// StatusChanged returns a channel on which the new status of the
+// connection is sent whenever it changes to one of the given statuses,
+// or to any status if none is given. The channel is closed after the
+// connection is closed, or when it is passed to RemoveStatusListener.
+//
+// Statuses are delivered in order through the same goroutine as the
+// connection's callbacks, but dropped if the channel's buffer is full,
+// so the channel should be drained promptly.
+func (nc *Conn) StatusChanged(statuses ...Status) <-chan Status {
+	ch := make(chan Status, statusChanLen)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		close(ch)
+		return ch
+	}
+	nc.statusListeners = append(nc.statusListeners, &statusListener{ch: ch, statuses: statuses})
+	return ch
+}
+
+// RemoveStatusListener stops the delivery of statuses to the channel
+// returned by StatusChanged, and closes it.
+func (nc *Conn) RemoveStatusListener(ch <-chan Status) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	for i, l := range nc.statusListeners {
+		if l.ch == ch {
+			nc.statusListeners = append(nc.statusListeners[:i], nc.statusListeners[i+1:]...)
+			// Close after the statuses already queued have been delivered.
+			nc.ach.push(func() { close(l.ch) })
+			return
+		}
+	}
+}
+
+// Events returns a channel on which the connection events of the given
+// types, or of any type if none is given, are sent. The channel is closed
+// after the ClosedEvent, or when it is passed to RemoveEventListener.
+// Events are delivered like the statuses of StatusChanged.
+func (nc *Conn) Events(types ...ConnEventType) <-chan ConnEvent {
+	ch := make(chan ConnEvent, statusChanLen)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		close(ch)
+		return ch
+	}
+	nc.eventListeners = append(nc.eventListeners, &eventListener{ch: ch, types: types})
+	return ch
+}
+
+// RemoveEventListener stops the delivery of events to the channel
+// returned by Events, and closes it.
+func (nc *Conn) RemoveEventListener(ch <-chan ConnEvent) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	for i, l := range nc.eventListeners {
+		if l.ch == ch {
+			nc.eventListeners = append(nc.eventListeners[:i], nc.eventListeners[i+1:]...)
+			nc.ach.push(func() { close(l.ch) })
+			return
+		}
+	}
+}
+
+// emitEvent queues the delivery of the event, and of the new status if
+// it changed, to the registered listeners. On ClosedEvent, the listeners
+// are removed and their channels closed after the delivery.
+// Lock should be held.
+func (nc *Conn) emitEvent(typ ConnEventType, statusChanged bool, err error) {
+	if len(nc.statusListeners) == 0 && len(nc.eventListeners) == 0 {
+		return
+	}
+	ev := ConnEvent{Type: typ, Status: nc.status, Err: err, Time: time.Now()}
+	if nc.current != nil && nc.current.url != nil {
+		ev.Server = nc.current.url.String()
+	}
+	var schs []chan Status
+	if statusChanged {
+		for _, l := range nc.statusListeners {
+			if l.wants(ev.Status) {
+				schs = append(schs, l.ch)
+			}
+		}
+	}
+	var echs []chan ConnEvent
+	for _, l := range nc.eventListeners {
+		if l.wants(typ) {
+			echs = append(echs, l.ch)
+		}
+	}
+	var sclose []chan Status
+	var eclose []chan ConnEvent
+	if typ == ClosedEvent {
+		for _, l := range nc.statusListeners {
+			sclose = append(sclose, l.ch)
+		}
+		for _, l := range nc.eventListeners {
+			eclose = append(eclose, l.ch)
+		}
+		nc.statusListeners, nc.eventListeners = nil, nil
+	}
+	nc.ach.push(func() {
+		for _, ch := range schs {
+			select {
+			case ch <- ev.Status:
+			default:
+			}
+		}
+		for _, ch := range echs {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+		for _, ch := range sclose {
+			close(ch)
+		}
+		for _, ch := range eclose {
+			close(ch)
+		}
+	})
+}
+
+func (l *statusListener) wants(s Status) bool {
+	if len(l.statuses) == 0 {
+		return true
+	}
+	for _, ls := range l.statuses {
+		if ls == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *eventListener) wants(t ConnEventType) bool {
+	if len(l.types) == 0 {
+		return true
+	}
+	for _, lt := range l.types {
+		if lt == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package subject provides utilities to validate, match and transform
+// NATS subjects.
+//
+// Subjects are made of tokens separated by '.'. Filters are subjects that
+// may contain the '*' wildcard, matching any single token, and the '>'
+// wildcard, matching one or more tokens, which can only be the last token.
+package subject
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// Pwc is the partial wildcard, matching a single token.
+	Pwc = "*"
+	// Fwc is the full wildcard, matching one or more trailing tokens.
+	Fwc = ">"
+	// Sep is the token separator.
+	Sep = "."
+)
+
+var (
+	ErrInvalidSubject = errors.New("subject: invalid subject")
+	ErrInvalidFilter  = errors.New("subject: invalid filter")
+)
+
+// Tokens returns the tokens of the subject.
+func Tokens(subj string) []string {
+	return strings.Split(subj, Sep)
+}
+
+// Token returns the token at the given zero-based index, or
+// an empty string if the subject has fewer tokens.
+func Token(subj string, index int) string {
+	if index < 0 {
+		return ""
+	}
+	for i := 0; i < index; i++ {
+		j := strings.IndexByte(subj, '.')
+		if j < 0 {
+			return ""
+		}
+		subj = subj[j+1:]
+	}
+	if j := strings.IndexByte(subj, '.'); j >= 0 {
+		return subj[:j]
+	}
+	return subj
+}
+
+// NumTokens returns the number of tokens of the subject.
+func NumTokens(subj string) int {
+	if subj == "" {
+		return 0
+	}
+	return strings.Count(subj, Sep) + 1
+}
+
+// IsValidSubject reports whether `subj` is a valid subject to publish
+// to: not empty, without whitespace, empty tokens nor wildcards.
+func IsValidSubject(subj string) bool {
+	return isValid(subj, false)
+}
+
+// IsValidFilter reports whether `filter` is a valid subscription filter:
+// not empty, without whitespace nor empty tokens, and with '>' only as
+// the last token. Wildcards are only special as complete tokens.
+func IsValidFilter(filter string) bool {
+	return isValid(filter, true)
+}
+
+func isValid(subj string, wildcards bool) bool {
+	if subj == "" || strings.ContainsAny(subj, " \t\r\n\f") {
+		return false
+	}
+	tokens := Tokens(subj)
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return false
+		case t == Pwc || t == Fwc:
+			if !wildcards || (t == Fwc && i != len(tokens)-1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsLiteral reports whether the subject contains no wildcard token.
+func IsLiteral(subj string) bool {
+	for _, t := range Tokens(subj) {
+		if t == Pwc || t == Fwc {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether the literal subject matches the filter.
+func Matches(filter, subj string) bool {
+	return tokensMatch(Tokens(filter), Tokens(subj))
+}
+
+func tokensMatch(filter, tokens []string) bool {
+	for i, f := range filter {
+		if f == Fwc {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (f != Pwc && f != tokens[i]) {
+			return false
+		}
+	}
+	return len(filter) == len(tokens)
+}
+
+// IsSubsetOf reports whether all the subjects matching `filter` also
+// match `of`. A literal subject is a subset of the filters it matches.
+func IsSubsetOf(filter, of string) bool {
+	return tokensSubset(Tokens(filter), Tokens(of))
+}
+
+func tokensSubset(filter, of []string) bool {
+	for i, o := range of {
+		if i >= len(filter) {
+			return false
+		}
+		f := filter[i]
+		if o == Fwc {
+			return true
+		}
+		if f == Fwc || (o != Pwc && (f == Pwc || f != o)) {
+			return false
+		}
+	}
+	return len(filter) == len(of)
+}
+
+// Overlaps reports whether at least one subject matches both filters.
+// JetStream, for instance, does not allow streams with overlapping subjects.
+func Overlaps(a, b string) bool {
+	at, bt := Tokens(a), Tokens(b)
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		if at[i] == Fwc || bt[i] == Fwc {
+			return true
+		}
+		if at[i] != Pwc && bt[i] != Pwc && at[i] != bt[i] {
+			return false
+		}
+	}
+	return len(at) == len(bt)
+}
@@ -0,0 +1,284 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestValidation(t *testing.T) {
+	for _, test := range []struct {
+		subj    string
+		subject bool
+		filter  bool
+	}{
+		{"foo", true, true},
+		{"foo.bar", true, true},
+		{"foo.*", false, true},
+		{"foo.>", false, true},
+		{">", false, true},
+		{"foo.*.bar", false, true},
+		{"foo*.bar", true, true},
+		{"foo.>.bar", false, false},
+		{"", false, false},
+		{"foo..bar", false, false},
+		{".foo", false, false},
+		{"foo.", false, false},
+		{"foo bar", false, false},
+		{"foo\tbar", false, false},
+	} {
+		if v := IsValidSubject(test.subj); v != test.subject {
+			t.Fatalf("Expected IsValidSubject(%q) to be %v", test.subj, test.subject)
+		}
+		if v := IsValidFilter(test.subj); v != test.filter {
+			t.Fatalf("Expected IsValidFilter(%q) to be %v", test.subj, test.filter)
+		}
+	}
+	if !IsLiteral("foo.bar") || IsLiteral("foo.*") || IsLiteral("foo.>") {
+		t.Fatal("Unexpected IsLiteral result")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	subj := "foo.bar.baz"
+	if n := NumTokens(subj); n != 3 {
+		t.Fatalf("Expected 3 tokens, got %v", n)
+	}
+	if n := NumTokens(""); n != 0 {
+		t.Fatalf("Expected 0 tokens, got %v", n)
+	}
+	for i, expected := range []string{"foo", "bar", "baz", ""} {
+		if tok := Token(subj, i); tok != expected {
+			t.Fatalf("Expected token %v to be %q, got %q", i, expected, tok)
+		}
+	}
+	if tok := Token(subj, -1); tok != "" {
+		t.Fatalf("Unexpected token %q", tok)
+	}
+	if tokens := Tokens(subj); len(tokens) != 3 || tokens[1] != "bar" {
+		t.Fatalf("Unexpected tokens %v", tokens)
+	}
+}
+
+func TestMatchesAndSubsets(t *testing.T) {
+	for _, test := range []struct {
+		filter, subj string
+		matches      bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"foo.*", "foo.bar", true},
+		{"foo.*", "foo.bar.baz", false},
+		{"foo.*", "foo", false},
+		{"foo.>", "foo.bar.baz", true},
+		{"foo.>", "foo", false},
+		{"*.bar", "foo.bar", true},
+		{">", "foo", true},
+	} {
+		if m := Matches(test.filter, test.subj); m != test.matches {
+			t.Fatalf("Expected Matches(%q, %q) to be %v", test.filter, test.subj, test.matches)
+		}
+	}
+
+	for _, test := range []struct {
+		filter, of string
+		subset     bool
+		overlaps   bool
+	}{
+		{"foo.bar", "foo.*", true, true},
+		{"foo.*", "foo.bar", false, true},
+		{"foo.*", "foo.>", true, true},
+		{"foo.>", "foo.*", false, true},
+		{"foo.*.baz", "foo.>", true, true},
+		{"foo.>", ">", true, true},
+		{"foo.*", "*.bar", false, true},
+		{"foo.*", "bar.*", false, false},
+		{"foo.*", "foo.*.*", false, false},
+		{"foo.bar.>", "foo.*", false, false},
+		{"foo.>", "foo", false, false},
+	} {
+		if s := IsSubsetOf(test.filter, test.of); s != test.subset {
+			t.Fatalf("Expected IsSubsetOf(%q, %q) to be %v", test.filter, test.of, test.subset)
+		}
+		if o := Overlaps(test.filter, test.of); o != test.overlaps {
+			t.Fatalf("Expected Overlaps(%q, %q) to be %v", test.filter, test.of, test.overlaps)
+		}
+		if o := Overlaps(test.of, test.filter); o != test.overlaps {
+			t.Fatalf("Expected Overlaps(%q, %q) to be %v", test.of, test.filter, test.overlaps)
+		}
+	}
+}
+
+func TestSublist(t *testing.T) {
+	s := NewSublist()
+	filters := []string{"foo.bar", "foo.*", "foo.>", "*.bar", ">", "foo.bar.baz", "foo.*.baz"}
+	for _, f := range filters {
+		if err := s.Insert(f, f); err != nil {
+			t.Fatalf("Error inserting %q: %v", f, err)
+		}
+	}
+	if err := s.Insert("foo..bar", 1); err != ErrInvalidFilter {
+		t.Fatalf("Expected %v, got %v", ErrInvalidFilter, err)
+	}
+	if n := s.Count(); n != len(filters) {
+		t.Fatalf("Expected %v values, got %v", len(filters), n)
+	}
+
+	check := func(subj string, expected ...string) {
+		t.Helper()
+		var got []string
+		for _, v := range s.Match(subj) {
+			got = append(got, v.(string))
+		}
+		sort.Strings(got)
+		sort.Strings(expected)
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Fatalf("Expected %q to match %v, got %v", subj, expected, got)
+		}
+	}
+	check("foo.bar", "foo.bar", "foo.*", "foo.>", "*.bar", ">")
+	check("foo.bar.baz", "foo.>", ">", "foo.bar.baz", "foo.*.baz")
+	check("foo", ">")
+	check("bar.bar", "*.bar", ">")
+	check("bar.baz.bat", ">")
+
+	// Removal invalidates the cache and prunes the trie.
+	if err := s.Remove(">", ">"); err != nil {
+		t.Fatalf("Error removing: %v", err)
+	}
+	if err := s.Remove(">", ">"); err != ErrNotFound {
+		t.Fatalf("Expected %v, got %v", ErrNotFound, err)
+	}
+	if err := s.Remove("foo.bar", "other"); err != ErrNotFound {
+		t.Fatalf("Expected %v, got %v", ErrNotFound, err)
+	}
+	check("foo")
+	check("foo.bar", "foo.bar", "foo.*", "foo.>", "*.bar")
+	for _, f := range filters[:len(filters)-2] {
+		if f != ">" {
+			s.Remove(f, f)
+		}
+	}
+	check("foo.bar.baz", "foo.bar.baz", "foo.*.baz")
+	s.Remove("foo.bar.baz", "foo.bar.baz")
+	s.Remove("foo.*.baz", "foo.*.baz")
+	if n := s.Count(); n != 0 {
+		t.Fatalf("Expected no values, got %v", n)
+	}
+	if n := s.root.numNodes(); n != 0 {
+		t.Fatalf("Expected empty trie, got %v nodes", n)
+	}
+
+	// Duplicate values.
+	s.Insert("foo", 1)
+	s.Insert("foo", 1)
+	if n := len(s.Match("foo")); n != 2 {
+		t.Fatalf("Expected 2 matches, got %v", n)
+	}
+	s.Remove("foo", 1)
+	if n := len(s.Match("foo")); n != 1 {
+		t.Fatalf("Expected 1 match, got %v", n)
+	}
+
+	// The cache evicts entries when full, rather than being reset.
+	for i := 0; i < 2*sublistCacheMax; i++ {
+		s.Match(fmt.Sprintf("foo.%d", i))
+	}
+	if n := len(s.cache); n < sublistCacheMax*3/4 || n > sublistCacheMax {
+		t.Fatalf("Unexpected number of cached results: %v", n)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	for _, test := range []struct {
+		src, dest, subj, expected string
+	}{
+		{"foo.*.*", "bar.$2.$1", "foo.a.b", "bar.b.a"},
+		{"foo.*.*", "bar.{{wildcard(2)}}.{{wildcard(1)}}", "foo.a.b", "bar.b.a"},
+		{"foo.*.>", "bar.$1.>", "foo.a.b.c", "bar.a.b.c"},
+		{"foo.>", "bar.>", "foo.a", "bar.a"},
+		{"foo", "bar", "foo", "bar"},
+		{"foo.*", "bar.baz", "foo.a", "bar.baz"},
+	} {
+		tr, err := NewTransform(test.src, test.dest)
+		if err != nil {
+			t.Fatalf("Error creating transform %q -> %q: %v", test.src, test.dest, err)
+		}
+		got, err := tr.Apply(test.subj)
+		if err != nil {
+			t.Fatalf("Error applying transform to %q: %v", test.subj, err)
+		}
+		if got != test.expected {
+			t.Fatalf("Expected %q, got %q", test.expected, got)
+		}
+	}
+
+	tr, _ := NewTransform("foo.*", "bar.$1")
+	if _, err := tr.Apply("baz.a"); err != ErrNoMatch {
+		t.Fatalf("Expected %v, got %v", ErrNoMatch, err)
+	}
+	for _, test := range [][2]string{
+		{"foo.*", "bar.$2"},
+		{"foo.*", "bar.$0"},
+		{"foo.*", "bar.*"},
+		{"foo.*", "bar.>"},
+		{"foo..*", "bar"},
+		{"foo.*", "bar..$1"},
+	} {
+		if _, err := NewTransform(test[0], test[1]); err != ErrInvalidTransform {
+			t.Fatalf("Expected %v for %q -> %q, got %v", ErrInvalidTransform, test[0], test[1], err)
+		}
+	}
+}
+
+func BenchmarkSublistMatch(b *testing.B) {
+	s := NewSublist()
+	for i := 0; i < 1000; i++ {
+		s.Insert(fmt.Sprintf("orders.%d.*", i), i)
+		s.Insert(fmt.Sprintf("orders.*.%d", i), i)
+	}
+	s.Insert("orders.>", -1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Match("orders.42.42")
+	}
+}
+
+func BenchmarkSublistMatchManySubjects(b *testing.B) {
+	s := NewSublist()
+	for i := 0; i < 1000; i++ {
+		s.Insert(fmt.Sprintf("orders.%d.*", i), i)
+	}
+	s.Insert("orders.>", -1)
+	// More distinct subjects than the cache can hold,
+	// every other match being on one of a few hot subjects.
+	subjects := make([]string, 16*sublistCacheMax)
+	for i := range subjects {
+		subjects[i] = fmt.Sprintf("orders.%d.%d", i%1000, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%2 == 0 {
+				s.Match(subjects[i%64])
+			} else {
+				s.Match(subjects[i%len(subjects)])
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,241 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"errors"
+	"sync"
+)
+
+// sublistCacheMax is the maximum number of cached match results.
+const sublistCacheMax = 1024
+
+var ErrNotFound = errors.New("subject: value not found")
+
+// Sublist associates values with filters, and efficiently returns the
+// values whose filter matches a subject. It is safe for concurrent use.
+// Match results are cached until the next modification.
+type Sublist struct {
+	mu    sync.RWMutex
+	root  *level
+	count int
+	// Incremented on every modification, so that the results of matches
+	// done concurrently with a modification are not cached.
+	genid uint64
+	cache map[string][]interface{}
+}
+
+// A level holds the nodes of a token position in the trie.
+type level struct {
+	nodes map[string]*node
+	pwc   *node
+	fwc   *node
+}
+
+type node struct {
+	next   *level
+	values []interface{}
+}
+
+func newLevel() *level {
+	return &level{nodes: make(map[string]*node)}
+}
+
+func (l *level) numNodes() int {
+	n := len(l.nodes)
+	if l.pwc != nil {
+		n++
+	}
+	if l.fwc != nil {
+		n++
+	}
+	return n
+}
+
+// NewSublist returns an empty Sublist.
+func NewSublist() *Sublist {
+	return &Sublist{root: newLevel(), cache: make(map[string][]interface{})}
+}
+
+// Insert associates the value with the filter. A value may be inserted
+// several times, and must be comparable to be removed.
+func (s *Sublist) Insert(filter string, v interface{}) error {
+	if !IsValidFilter(filter) {
+		return ErrInvalidFilter
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.root
+	var n *node
+	for _, t := range Tokens(filter) {
+		switch t {
+		case Pwc:
+			if l.pwc == nil {
+				l.pwc = &node{}
+			}
+			n = l.pwc
+		case Fwc:
+			if l.fwc == nil {
+				l.fwc = &node{}
+			}
+			n = l.fwc
+		default:
+			n = l.nodes[t]
+			if n == nil {
+				n = &node{}
+				l.nodes[t] = n
+			}
+		}
+		if n.next == nil {
+			n.next = newLevel()
+		}
+		l = n.next
+	}
+	n.values = append(n.values, v)
+	s.count++
+	s.resetCache()
+	return nil
+}
+
+// Remove removes one association of the value with the filter.
+func (s *Sublist) Remove(filter string, v interface{}) error {
+	if !IsValidFilter(filter) {
+		return ErrInvalidFilter
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.remove(s.root, Tokens(filter), v) {
+		return ErrNotFound
+	}
+	s.count--
+	s.resetCache()
+	return nil
+}
+
+// remove removes the value from the node at the end of the tokens path,
+// pruning the nodes left empty, and reports whether it was found.
+func (s *Sublist) remove(l *level, tokens []string, v interface{}) bool {
+	var n *node
+	switch t := tokens[0]; t {
+	case Pwc:
+		n = l.pwc
+	case Fwc:
+		n = l.fwc
+	default:
+		n = l.nodes[t]
+	}
+	if n == nil {
+		return false
+	}
+	found := false
+	if len(tokens) == 1 {
+		for i, nv := range n.values {
+			if nv == v {
+				n.values = append(n.values[:i], n.values[i+1:]...)
+				found = true
+				break
+			}
+		}
+	} else {
+		found = s.remove(n.next, tokens[1:], v)
+	}
+	if found && len(n.values) == 0 && n.next.numNodes() == 0 {
+		switch t := tokens[0]; t {
+		case Pwc:
+			l.pwc = nil
+		case Fwc:
+			l.fwc = nil
+		default:
+			delete(l.nodes, t)
+		}
+	}
+	return found
+}
+
+// Match returns the values whose filter matches the literal subject.
+// The returned slice must not be modified.
+func (s *Sublist) Match(subj string) []interface{} {
+	s.mu.RLock()
+	res, ok := s.cache[subj]
+	if ok {
+		s.mu.RUnlock()
+		return res
+	}
+	genid := s.genid
+	res = matchLevel(s.root, Tokens(subj), nil)
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	// Do not cache the result if the Sublist was modified meanwhile.
+	if s.genid == genid {
+		// Evict a quarter of the entries at random, relying on the map
+		// iteration order, rather than losing the whole cache.
+		if len(s.cache) >= sublistCacheMax {
+			for k := range s.cache {
+				delete(s.cache, k)
+				if len(s.cache) <= sublistCacheMax*3/4 {
+					break
+				}
+			}
+		}
+		s.cache[subj] = res
+	}
+	s.mu.Unlock()
+	return res
+}
+
+func matchLevel(l *level, tokens []string, res []interface{}) []interface{} {
+	for i, t := range tokens {
+		if l == nil {
+			return res
+		}
+		if l.fwc != nil {
+			res = append(res, l.fwc.values...)
+		}
+		if l.pwc != nil {
+			res = matchNode(l.pwc, tokens[i+1:], res)
+		}
+		n := l.nodes[t]
+		if n == nil {
+			return res
+		}
+		if i == len(tokens)-1 {
+			return append(res, n.values...)
+		}
+		l = n.next
+	}
+	return res
+}
+
+func matchNode(n *node, rest []string, res []interface{}) []interface{} {
+	if len(rest) == 0 {
+		return append(res, n.values...)
+	}
+	return matchLevel(n.next, rest, res)
+}
+
+// Count returns the number of values in the Sublist.
+func (s *Sublist) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.count
+}
+
+// Lock should be held.
+func (s *Sublist) resetCache() {
+	s.genid++
+	if len(s.cache) > 0 {
+		s.cache = make(map[string][]interface{})
+	}
+}
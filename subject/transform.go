@@ -0,0 +1,123 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidTransform = errors.New("subject: invalid transform")
+	ErrNoMatch          = errors.New("subject: subject does not match transform source")
+)
+
+// Transform maps the subjects matching a source filter to a destination
+// subject. The destination may reference the tokens matched by the
+// source's '*' wildcards, numbered from 1, with "$1" or "{{wildcard(1)}}"
+// tokens. If both end with '>', the tokens matched by the source's '>'
+// replace the destination's.
+type Transform struct {
+	src  []string
+	dest []string
+	// For each destination token, the position of the source token
+	// it is mapped from, or -1 for literal tokens.
+	pos []int
+	fwc bool
+}
+
+// NewTransform returns the transform from `src` to `dest`.
+func NewTransform(src, dest string) (*Transform, error) {
+	if !IsValidFilter(src) || !IsValidFilter(dest) {
+		return nil, ErrInvalidTransform
+	}
+	tr := &Transform{src: Tokens(src), dest: Tokens(dest)}
+	// Positions of the '*' wildcards in the source.
+	var pwcs []int
+	for i, t := range tr.src {
+		if t == Pwc {
+			pwcs = append(pwcs, i)
+		}
+	}
+	tr.pos = make([]int, len(tr.dest))
+	for i, t := range tr.dest {
+		tr.pos[i] = -1
+		switch {
+		case t == Pwc:
+			return nil, ErrInvalidTransform
+		case t == Fwc:
+			if tr.src[len(tr.src)-1] != Fwc {
+				return nil, ErrInvalidTransform
+			}
+			tr.fwc = true
+		default:
+			n, ok := placeholder(t)
+			if !ok {
+				continue
+			}
+			if n < 1 || n > len(pwcs) {
+				return nil, ErrInvalidTransform
+			}
+			tr.pos[i] = pwcs[n-1]
+		}
+	}
+	return tr, nil
+}
+
+// placeholder returns the wildcard number of a "$N" or
+// "{{wildcard(N)}}" token.
+func placeholder(t string) (int, bool) {
+	var num string
+	switch {
+	case len(t) > 1 && t[0] == '$':
+		num = t[1:]
+	case strings.HasPrefix(t, "{{") && strings.HasSuffix(t, "}}"):
+		fn := t[2 : len(t)-2]
+		if !strings.HasPrefix(fn, "wildcard(") || !strings.HasSuffix(fn, ")") {
+			return 0, false
+		}
+		num = fn[len("wildcard(") : len(fn)-1]
+	default:
+		return 0, false
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Apply returns the destination subject for the given literal subject.
+func (tr *Transform) Apply(subj string) (string, error) {
+	tokens := Tokens(subj)
+	if !tokensMatch(tr.src, tokens) {
+		return "", ErrNoMatch
+	}
+	var sb strings.Builder
+	for i, t := range tr.dest {
+		if i > 0 {
+			sb.WriteString(Sep)
+		}
+		switch {
+		case t == Fwc:
+			sb.WriteString(strings.Join(tokens[len(tr.src)-1:], Sep))
+		case tr.pos[i] >= 0:
+			sb.WriteString(tokens[tr.pos[i]])
+		default:
+			sb.WriteString(t)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestUpdateAuth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on an ephemeral port: %v", err)
+	}
+	defer l.Close()
+
+	// Fake server reporting the CONNECT protocols it receives.
+	connects := make(chan map[string]interface{}, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Write([]byte("INFO {\"server_id\":\"auth\"}\r\n"))
+				br := bufio.NewReader(conn)
+				line, _ := br.ReadString('\n')
+				var connect map[string]interface{}
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect)
+				connects <- connect
+				// PING
+				br.ReadString('\n')
+				conn.Write([]byte("PONG\r\n"))
+				io.Copy(ioutil.Discard, br)
+			}(conn)
+		}
+	}()
+
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s", l.Addr()),
+		nats.UserInfo("user", "pwd"),
+		nats.ReconnectWait(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	waitForConnect := func() map[string]interface{} {
+		t.Helper()
+		select {
+		case c := <-connects:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not get CONNECT")
+		}
+		return nil
+	}
+	if c := waitForConnect(); c["user"] != "user" || c["pass"] != "pwd" {
+		t.Fatalf("Unexpected CONNECT: %v", c)
+	}
+
+	if err := nc.UpdateAuth(false, nats.Token("secret")); err != nil {
+		t.Fatalf("Error updating auth: %v", err)
+	}
+	if err := nc.Reconnect(); err != nil {
+		t.Fatalf("Error reconnecting: %v", err)
+	}
+	if c := waitForConnect(); c["auth_token"] != "secret" || c["user"] != nil {
+		t.Fatalf("Unexpected CONNECT: %v", c)
+	}
+	if nc.Opts.User != "" || nc.Opts.Password != "" {
+		t.Fatalf("Expected user and password to be cleared, got %q/%q", nc.Opts.User, nc.Opts.Password)
+	}
+
+	// Conflicting options are rejected, and the settings left unchanged.
+	if err := nc.UpdateAuth(false, nats.Token("other"), nats.TokenHandler(func() string { return "" })); err != nats.ErrTokenAlreadySet {
+		t.Fatalf("Expected %v, got %v", nats.ErrTokenAlreadySet, err)
+	}
+	sigCB := func([]byte) ([]byte, error) { return nil, nil }
+	jwtCB := func() (string, error) { return "jwt", nil }
+	for _, test := range []struct {
+		opts []nats.Option
+		err  error
+	}{
+		{[]nats.Option{nats.UserJWT(jwtCB, sigCB), nats.Nkey("UNKEY", sigCB)}, nats.ErrNkeyAndUser},
+		{[]nats.Option{func(o *nats.Options) error { o.Nkey = "UNKEY"; return nil }}, nats.ErrNkeyButNoSigCB},
+		{[]nats.Option{nats.Token("other"), func(o *nats.Options) error { o.TokenHandler = func() string { return "" }; return nil }}, nats.ErrTokenAlreadySet},
+	} {
+		if err := nc.UpdateAuth(false, test.opts...); err != test.err {
+			t.Fatalf("Expected %v, got %v", test.err, err)
+		}
+	}
+	if nc.Opts.Token != "secret" || nc.Opts.Nkey != "" {
+		t.Fatalf("Expected settings to be unchanged, got %q/%q", nc.Opts.Token, nc.Opts.Nkey)
+	}
+
+	// The new settings can be applied immediately.
+	if err := nc.UpdateAuth(true, nats.UserInfo("other", "pwd2")); err != nil {
+		t.Fatalf("Error updating auth: %v", err)
+	}
+	if c := waitForConnect(); c["user"] != "other" || c["pass"] != "pwd2" || c["auth_token"] != nil {
+		t.Fatalf("Unexpected CONNECT: %v", c)
+	}
+
+	if err := nc.UpdateTLSConfig(nil, false); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	nc.Close()
+	if err := nc.UpdateAuth(false, nats.Token("secret")); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+	if err := nc.UpdateTLSConfig(&tls.Config{}, true); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
@@ -82,6 +82,61 @@ func BenchmarkPubSubSpeed(b *testing.B) {
 	b.StopTimer()
 }
 
+func benchmarkPubSubDelivery(b *testing.B, pooled bool) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(b)
+	defer nc.Close()
+
+	ch := make(chan bool)
+
+	nc.SetErrorHandler(func(nc *nats.Conn, s *nats.Subscription, err error) {
+		b.Fatalf("Error : %v\n", err)
+	})
+
+	received := int32(0)
+
+	sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		m.Release()
+		if nr := atomic.AddInt32(&received, 1); nr >= int32(b.N) {
+			ch <- true
+		}
+	})
+	if err != nil {
+		b.Fatalf("Error on subscribe: %v", err)
+	}
+	sub.SetPendingLimits(-1, -1)
+	if err := sub.SetMsgPooling(pooled); err != nil {
+		b.Fatalf("Error setting message pooling: %v", err)
+	}
+
+	msg := make([]byte, 1024)
+
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := nc.Publish("foo", msg); err != nil {
+			b.Fatalf("Error in benchmark during Publish: %v\n", err)
+		}
+	}
+
+	// Make sure they are all processed.
+	if err := WaitTime(ch, 10*time.Second); err != nil {
+		b.Fatal("Timed out waiting for messages")
+	}
+	b.StopTimer()
+}
+
+func BenchmarkPubSubDelivery(b *testing.B) {
+	benchmarkPubSubDelivery(b, false)
+}
+
+func BenchmarkPubSubPooledDelivery(b *testing.B) {
+	benchmarkPubSubDelivery(b, true)
+}
+
 func BenchmarkAsyncSubscriptionCreationSpeed(b *testing.B) {
 	b.StopTimer()
 	s := RunDefaultServer()
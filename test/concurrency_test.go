@@ -0,0 +1,195 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestSubscriptionConcurrency(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// Callbacks block until 4 of them run concurrently.
+	workers := 4
+	var running, maxRunning int32
+	release := make(chan struct{})
+	done := make(chan bool, workers)
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		if n == int32(workers) {
+			close(release)
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		done <- true
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.SetConcurrency(workers, nil); err != nil {
+		t.Fatalf("Error setting concurrency: %v", err)
+	}
+	for i := 0; i < workers; i++ {
+		nc.Publish("foo", []byte("hello"))
+	}
+	nc.Flush()
+	for i := 0; i < workers; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Callbacks did not run concurrently, max running: %v", atomic.LoadInt32(&maxRunning))
+		}
+	}
+	if n, _, _ := sub.Pending(); n != 0 {
+		t.Fatalf("Expected no pending messages, got %v", n)
+	}
+
+	// Errors.
+	if err := sub.SetConcurrency(0, nil); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	ssub, err := nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := ssub.SetConcurrency(2, nil); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+	sub.Unsubscribe()
+	if err := sub.SetConcurrency(2, nil); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+}
+
+func TestSubscriptionConcurrencyOrdering(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	total := 50
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	count := int32(0)
+	done := make(chan bool)
+	sub, err := nc.Subscribe("orders.*", func(m *nats.Msg) {
+		seq, _ := strconv.Atoi(string(m.Data))
+		// Slow down some messages.
+		if seq%7 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		received[m.Subject] = append(received[m.Subject], seq)
+		mu.Unlock()
+		if atomic.AddInt32(&count, 1) == int32(total*len(keys)) {
+			done <- true
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.SetConcurrency(3, nats.SubjectTokenKey(1)); err != nil {
+		t.Fatalf("Error setting concurrency: %v", err)
+	}
+	for i := 0; i < total; i++ {
+		for _, k := range keys {
+			nc.Publish("orders."+k, []byte(strconv.Itoa(i)))
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Received only %v messages", atomic.LoadInt32(&count))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range keys {
+		seqs := received["orders."+k]
+		if len(seqs) != total {
+			t.Fatalf("Expected %v messages for key %q, got %v", total, k, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("Messages out of order for key %q: %v", k, seqs)
+			}
+		}
+	}
+}
+
+func TestSubscriptionConcurrencyAutoUnsubscribe(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	max := 20
+	var count int32
+	sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&count, 1)
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	sub.SetConcurrency(4, nats.HeaderKey("Key"))
+	sub.AutoUnsubscribe(max)
+	for i := 0; i < 2*max; i++ {
+		m := nats.NewMsg("foo")
+		m.Header.Set("Key", fmt.Sprintf("%d", i%7))
+		nc.PublishMsg(m)
+	}
+	nc.Flush()
+
+	// All messages up to the maximum are processed before the
+	// subscription is removed.
+	deadline := time.Now().Add(2 * time.Second)
+	for sub.IsValid() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sub.IsValid() {
+		t.Fatal("Expected subscription to be removed")
+	}
+	if n := atomic.LoadInt32(&count); n != int32(max) {
+		t.Fatalf("Expected %v messages processed, got %v", max, n)
+	}
+}
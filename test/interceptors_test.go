@@ -0,0 +1,263 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestPublishInterceptors(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	errRejected := errors.New("rejected")
+	var mu sync.Mutex
+	var order []string
+
+	first := func(m *nats.Msg, next nats.PublishFunc) error {
+		mu.Lock()
+		order = append(order, "first")
+		mu.Unlock()
+		if strings.HasPrefix(m.Subject, "reject.") {
+			return errRejected
+		}
+		if m.Header == nil {
+			m.Header = nats.Header{}
+		}
+		m.Header.Set("X-Intercepted", "true")
+		return next(m)
+	}
+	second := func(m *nats.Msg, next nats.PublishFunc) error {
+		mu.Lock()
+		order = append(order, "second")
+		mu.Unlock()
+		m.Data = append([]byte("pre:"), m.Data...)
+		return next(m)
+	}
+
+	nc, err := nats.Connect(s.ClientURL(), nats.PublishInterceptors(first, second))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := nc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if got := string(msg.Data); got != "pre:hello" {
+		t.Fatalf("Unexpected payload: %q", got)
+	}
+	if got := msg.Header.Get("X-Intercepted"); got != "true" {
+		t.Fatalf("Expected header to be set by interceptor, got %q", got)
+	}
+	mu.Lock()
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Fatalf("Unexpected interceptors order: %v", order)
+	}
+	mu.Unlock()
+
+	// Interceptors can reject messages.
+	if err := nc.Publish("reject.foo", []byte("hello")); err != errRejected {
+		t.Fatalf("Expected error %v, got %v", errRejected, err)
+	}
+
+	// Existing headers are preserved.
+	m := nats.NewMsg("foo")
+	m.Header.Set("X-Existing", "1")
+	if err := nc.PublishMsg(m); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	msg, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if msg.Header.Get("X-Existing") != "1" || msg.Header.Get("X-Intercepted") != "true" {
+		t.Fatalf("Unexpected headers: %+v", msg.Header)
+	}
+	// The changes of the interceptors do not affect the published message.
+	if len(m.Header) != 1 || m.Data != nil {
+		t.Fatalf("Published message was modified: %+v", m)
+	}
+
+	// Requests are intercepted too.
+	rsub, err := nc.Subscribe("req", func(m *nats.Msg) {
+		m.Respond([]byte(m.Header.Get("X-Intercepted")))
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	defer rsub.Unsubscribe()
+	resp, err := nc.Request("req", nil, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if got := string(resp.Data); got != "pre:true" {
+		t.Fatalf("Unexpected response: %q", got)
+	}
+	if _, err := nc.Request("reject.req", nil, time.Second); err != errRejected {
+		t.Fatalf("Expected error %v, got %v", errRejected, err)
+	}
+}
+
+func TestDeliveryInterceptors(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	drop := func(m *nats.Msg, next nats.MsgHandler) {
+		if m.Header.Get("X-Drop") != "" {
+			return
+		}
+		next(m)
+	}
+	upper := func(m *nats.Msg, next nats.MsgHandler) {
+		m.Data = []byte(strings.ToUpper(string(m.Data)))
+		next(m)
+	}
+
+	nc, err := nats.Connect(s.ClientURL(), nats.DeliveryInterceptors(drop, upper))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	ch := make(chan string, 10)
+	if _, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		ch <- string(m.Data)
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	m := nats.NewMsg("foo")
+	m.Header.Set("X-Drop", "1")
+	m.Data = []byte("dropped")
+	nc.PublishMsg(m)
+	nc.Publish("foo", []byte("hello"))
+
+	select {
+	case got := <-ch:
+		if got != "HELLO" {
+			t.Fatalf("Unexpected message: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive message")
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("Unexpected message: %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Synchronous and channel based subscriptions, including those on
+	// inboxes, are intercepted too.
+	sub, err := nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	mch := make(chan *nats.Msg, 10)
+	if _, err := nc.ChanSubscribe("baz", mch); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	inbox := nats.NewInbox()
+	isub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for _, subj := range []string{"bar", "baz", inbox} {
+		m := nats.NewMsg(subj)
+		m.Header.Set("X-Drop", "1")
+		m.Data = []byte("dropped")
+		nc.PublishMsg(m)
+		nc.Publish(subj, []byte("hello"))
+	}
+	for _, s := range []*nats.Subscription{sub, isub} {
+		msg, err := s.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Did not receive message: %v", err)
+		}
+		if string(msg.Data) != "HELLO" {
+			t.Fatalf("Unexpected message: %q", msg.Data)
+		}
+		if msg, err := s.NextMsg(50 * time.Millisecond); err != nats.ErrTimeout {
+			t.Fatalf("Expected timeout, got %v, %v", msg, err)
+		}
+	}
+	select {
+	case msg := <-mch:
+		if string(msg.Data) != "HELLO" {
+			t.Fatalf("Unexpected message: %q", msg.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive message")
+	}
+	select {
+	case msg := <-mch:
+		t.Fatalf("Unexpected message: %q", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The responses to requests are not.
+	nc.Subscribe("svc", func(m *nats.Msg) {
+		resp := nats.NewMsg(m.Reply)
+		resp.Header.Set("X-Drop", "1")
+		resp.Data = []byte("ok")
+		m.RespondMsg(resp)
+	})
+	onc, err := nats.Connect(s.ClientURL(), nats.UseOldRequestStyle(), nats.DeliveryInterceptors(drop))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer onc.Close()
+	for _, c := range []*nats.Conn{nc, onc} {
+		resp, err := c.Request("svc", nil, time.Second)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if string(resp.Data) != "ok" {
+			t.Fatalf("Unexpected response: %q", resp.Data)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err = c.RequestWithContext(ctx, "svc", nil)
+		cancel()
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if string(resp.Data) != "ok" {
+			t.Fatalf("Unexpected response: %q", resp.Data)
+		}
+	}
+}
+
+func TestInterceptorsInvalidArg(t *testing.T) {
+	if _, err := nats.Connect(nats.DefaultURL, nats.PublishInterceptors(nil)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	if _, err := nats.Connect(nats.DefaultURL, nats.DeliveryInterceptors(nil)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestReconnectJournal(t *testing.T) {
+	s := RunServerOnPort(TEST_PORT)
+	defer func() { s.Shutdown() }()
+
+	dir := t.TempDir()
+	dch := make(chan bool, 1)
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", TEST_PORT),
+		nats.ReconnectBufSize(1024),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.MaxReconnects(-1),
+		nats.SetReconnectJournal(nats.ReconnectJournal{Dir: dir}),
+		nats.DisconnectHandler(func(_ *nats.Conn) { dch <- true }),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Flush()
+
+	s.Shutdown()
+	select {
+	case <-dch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not get disconnected")
+	}
+
+	// Publish well over the reconnect buffer size.
+	payload := make([]byte, 100)
+	total := 100
+	for i := 0; i < total; i++ {
+		if err := nc.Publish("foo", append([]byte(fmt.Sprintf("%03d", i)), payload...)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	if n, _ := nc.Buffered(); n < total*len(payload) {
+		t.Fatalf("Expected at least %v bytes buffered, got %v", total*len(payload), n)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("Expected journal directory to be created, got %v", entries)
+	}
+	segments, _ := ioutil.ReadDir(filepath.Join(dir, entries[0].Name()))
+	if len(segments) == 0 {
+		t.Fatal("Expected journal segments")
+	}
+
+	s = RunServerOnPort(TEST_PORT)
+	select {
+	case <-rch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not reconnect")
+	}
+
+	// Messages are replayed in order.
+	for i := 0; i < total; i++ {
+		msg, err := sub.NextMsg(2 * time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message %v: %v", i, err)
+		}
+		if seq := string(msg.Data[:3]); seq != fmt.Sprintf("%03d", i) {
+			t.Fatalf("Expected message %03d, got %s", i, seq)
+		}
+	}
+	if segments, _ := ioutil.ReadDir(filepath.Join(dir, entries[0].Name())); len(segments) != 0 {
+		t.Fatalf("Expected journal segments to be removed, got %v", len(segments))
+	}
+
+	nc.Close()
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("Expected journal directory to be removed, got %v", entries)
+	}
+}
+
+func TestReconnectJournalLimits(t *testing.T) {
+	s := RunServerOnPort(TEST_PORT)
+	defer func() { s.Shutdown() }()
+
+	type discard struct {
+		bytes  int64
+		reason error
+	}
+	discarded := make(chan discard, 100)
+	dch := make(chan bool, 1)
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", TEST_PORT),
+		nats.ReconnectBufSize(1024),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.MaxReconnects(-1),
+		nats.SetReconnectJournal(nats.ReconnectJournal{
+			Dir:      t.TempDir(),
+			MaxBytes: 4096,
+			DiscardedCB: func(_ *nats.Conn, bytes int64, reason error) {
+				discarded <- discard{bytes, reason}
+			},
+		}),
+		nats.DisconnectHandler(func(_ *nats.Conn) { dch <- true }),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Flush()
+
+	s.Shutdown()
+	select {
+	case <-dch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not get disconnected")
+	}
+
+	payload := make([]byte, 100)
+	total := 100
+	for i := 0; i < total; i++ {
+		if err := nc.Publish("foo", append([]byte(fmt.Sprintf("%03d", i)), payload...)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	// About 10KB were published, so at least 6KB were discarded.
+	var dropped int64
+	for dropped < 6*1024 {
+		select {
+		case d := <-discarded:
+			if d.reason != nats.ErrReconnectJournalFull {
+				t.Fatalf("Expected %v, got %v", nats.ErrReconnectJournalFull, d.reason)
+			}
+			dropped += d.bytes
+		case <-time.After(time.Second):
+			t.Fatalf("Expected more data to be discarded, got %v bytes", dropped)
+		}
+	}
+
+	s = RunServerOnPort(TEST_PORT)
+	select {
+	case <-rch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not reconnect")
+	}
+
+	// The oldest messages were discarded, the most recent ones are received in order.
+	var received []string
+	for {
+		msg, err := sub.NextMsg(250 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		received = append(received, string(msg.Data[:3]))
+	}
+	if len(received) == 0 || len(received) == total {
+		t.Fatalf("Unexpected number of messages received: %v", len(received))
+	}
+	if last := received[len(received)-1]; last != fmt.Sprintf("%03d", total-1) {
+		t.Fatalf("Expected last message to be received, got %s", last)
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Fatalf("Messages out of order: %v", received)
+		}
+	}
+
+	if err := nats.SetReconnectJournal(nats.ReconnectJournal{MaxBytes: -1})(&nats.Options{}); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
+func TestReconnectJournalDiscardedOnClose(t *testing.T) {
+	s := RunServerOnPort(TEST_PORT)
+	defer s.Shutdown()
+
+	type discard struct {
+		bytes  int64
+		reason error
+	}
+	discarded := make(chan discard, 10)
+	dch := make(chan bool, 1)
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", TEST_PORT),
+		nats.ReconnectBufSize(1024),
+		nats.ReconnectWait(time.Hour),
+		nats.SetReconnectJournal(nats.ReconnectJournal{
+			Dir: t.TempDir(),
+			DiscardedCB: func(_ *nats.Conn, bytes int64, reason error) {
+				discarded <- discard{bytes, reason}
+			},
+		}),
+		nats.DisconnectHandler(func(_ *nats.Conn) { dch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	s.Shutdown()
+	select {
+	case <-dch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not get disconnected")
+	}
+	payload := make([]byte, 100)
+	for i := 0; i < 50; i++ {
+		if err := nc.Publish("foo", payload); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+
+	// The data not replayed is reported when closing.
+	nc.Close()
+	select {
+	case d := <-discarded:
+		if d.reason != nats.ErrConnectionClosed || d.bytes < 1024 {
+			t.Fatalf("Unexpected discard: %v bytes, %v", d.bytes, d.reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Discarded data was not reported")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+type logEntry struct {
+	level nats.LogLevel
+	msg   string
+	kv    []interface{}
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	entries []logEntry
+	ch      chan logEntry
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{ch: make(chan logEntry, 1000)}
+}
+
+func (l *recordingLogger) Log(level nats.LogLevel, msg string, kv ...interface{}) {
+	e := logEntry{level, msg, kv}
+	l.Lock()
+	l.entries = append(l.entries, e)
+	l.Unlock()
+	select {
+	case l.ch <- e:
+	default:
+	}
+}
+
+func (l *recordingLogger) waitFor(t *testing.T, msg string) logEntry {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-l.ch:
+			if e.msg == msg {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("Did not get log entry %q", msg)
+		}
+	}
+}
+
+func TestLoggerLifecycleEvents(t *testing.T) {
+	s := RunServerOnPort(TEST_PORT)
+	url := s.ClientURL()
+
+	l := newRecordingLogger()
+	nc, err := nats.Connect(url,
+		nats.SetLogger(l),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.MaxReconnects(-1))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	e := l.waitFor(t, "connected")
+	if e.level != nats.LogLevelInfo {
+		t.Fatalf("Unexpected level: %v", e.level)
+	}
+	if len(e.kv) < 2 || e.kv[0] != "server" || e.kv[1] != url {
+		t.Fatalf("Unexpected fields: %v", e.kv)
+	}
+
+	s.Shutdown()
+	if e := l.waitFor(t, "disconnected"); e.level != nats.LogLevelWarn {
+		t.Fatalf("Unexpected level: %v", e.level)
+	}
+	if e := l.waitFor(t, "reconnect attempt"); e.level != nats.LogLevelDebug {
+		t.Fatalf("Unexpected level: %v", e.level)
+	}
+
+	s = RunServerOnPort(TEST_PORT)
+	defer s.Shutdown()
+	l.waitFor(t, "reconnected")
+
+	nc.Close()
+	l.waitFor(t, "connection closed")
+}
+
+func TestLoggerAsyncErrors(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	// Errors are logged, even with an error handler set.
+	l := newRecordingLogger()
+	errs := make(chan error, 10)
+	nc, err := nats.Connect(s.ClientURL(), nats.SetLogger(l),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) { errs <- err }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	block := make(chan struct{})
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) { <-block })
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	defer close(block)
+	sub.SetPendingLimits(1, -1)
+	for i := 0; i < 10; i++ {
+		nc.Publish("foo", []byte("hello"))
+	}
+	nc.Flush()
+
+	e := l.waitFor(t, "asynchronous error")
+	if e.level != nats.LogLevelWarn {
+		t.Fatalf("Unexpected level: %v", e.level)
+	}
+	fields := fmt.Sprint(e.kv...)
+	if !strings.Contains(fields, nats.ErrSlowConsumer.Error()) || !strings.Contains(fields, "foo") {
+		t.Fatalf("Unexpected fields: %v", e.kv)
+	}
+	select {
+	case err := <-errs:
+		if err != nats.ErrSlowConsumer {
+			t.Fatalf("Expected %v, got %v", nats.ErrSlowConsumer, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Error handler was not invoked")
+	}
+}
+
+func TestLoggerOutputFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := nats.NewLogger(&buf, nats.LogLevelInfo)
+	l.Log(nats.LogLevelDebug, "not logged")
+	l.Log(nats.LogLevelWarn, "disconnected", "server", "nats://127.0.0.1:4222", "error", "nats: stale connection", "odd")
+
+	out := buf.String()
+	if strings.Contains(out, "not logged") {
+		t.Fatalf("Debug entry should have been discarded: %q", out)
+	}
+	for _, expected := range []string{
+		"time=",
+		` level=WARN msg=disconnected server=nats://127.0.0.1:4222 error="nats: stale connection" odd=""`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in %q", expected, out)
+		}
+	}
+	if !strings.HasSuffix(out, "\n") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("Expected a single line, got %q", out)
+	}
+}
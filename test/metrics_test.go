@@ -0,0 +1,139 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestMetrics(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.EnableMetrics(2))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("orders.new.>")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := nc.Subscribe("svc.echo", func(m *nats.Msg) {
+		m.Respond(m.Data)
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := nc.Publish("orders.new.eu", []byte("hello")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Did not receive message: %v", err)
+		}
+	}
+	if _, err := nc.Request("svc.echo", []byte("ping"), time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if _, err := nc.Request("svc.none", nil, time.Second); err == nil {
+		t.Fatal("Expected request to fail")
+	}
+	if _, err := nc.RTT(); err != nil {
+		t.Fatalf("Error on RTT: %v", err)
+	}
+
+	m := nc.Metrics()
+	if m.OutMsgs == 0 || m.InMsgs == 0 {
+		t.Fatalf("Expected statistics to be included: %+v", m.Statistics)
+	}
+	orders := m.Subjects["orders.new"]
+	if orders.OutMsgs != 3 || orders.InMsgs != 3 || orders.OutBytes != 15 || orders.InBytes != 15 {
+		t.Fatalf("Unexpected subject metrics: %+v", orders)
+	}
+	if m.RequestLatency.Count != 1 || m.RequestErrors != 1 {
+		t.Fatalf("Unexpected request metrics: %+v %v", m.RequestLatency, m.RequestErrors)
+	}
+	if len(m.RTTHistory) == 0 || m.RTT.Count == 0 {
+		t.Fatalf("Expected RTT samples, got %v", m.RTTHistory)
+	}
+	// Includes the subscription used for the responses of requests.
+	if len(m.Subscriptions) != 3 {
+		t.Fatalf("Expected 3 subscriptions, got %+v", m.Subscriptions)
+	}
+	for _, sm := range m.Subscriptions {
+		if sm.Subject == "orders.new.>" && sm.Delivered != 3 {
+			t.Fatalf("Unexpected subscription metrics: %+v", sm)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	nc.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	out := string(body)
+	for _, expected := range []string{
+		"# TYPE nats_client_in_msgs_total counter\n",
+		`nats_client_subject_out_msgs_total{subject="orders.new"} 3` + "\n",
+		`nats_client_subscription_delivered_total{subject="orders.new.>",queue=""} 3` + "\n",
+		"# TYPE nats_client_request_duration_seconds histogram\n",
+		`nats_client_request_duration_seconds_bucket{le="+Inf"} 1` + "\n",
+		"nats_client_request_errors_total 1\n",
+		"nats_client_rtt_seconds_count ",
+		`nats_client_js_ack_duration_seconds_count{type="publish"} 0` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+
+	m := nc.Metrics()
+	if m.OutMsgs != 1 {
+		t.Fatalf("Expected statistics to be included: %+v", m.Statistics)
+	}
+	if m.Subjects != nil || m.RequestLatency.Count != 0 {
+		t.Fatalf("Expected no extended metrics, got %+v", m)
+	}
+
+	rec := httptest.NewRecorder()
+	nc.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	if !strings.Contains(out, "nats_client_out_msgs_total 1\n") {
+		t.Fatalf("Unexpected output:\n%s", out)
+	}
+	if strings.Contains(out, "nats_client_subject_") {
+		t.Fatalf("Did not expect subject metrics:\n%s", out)
+	}
+}
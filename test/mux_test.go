@@ -0,0 +1,134 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestMux(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	mux, err := nc.NewMux("orders.>")
+	if err != nil {
+		t.Fatalf("Error creating mux: %v", err)
+	}
+	routed := make(chan string, 10)
+	handler := func(name string) nats.MsgHandler {
+		return func(m *nats.Msg) { routed <- name + " " + m.Subject }
+	}
+	for _, subj := range []string{"orders.>", "orders.*.new", "orders.us.>", "orders.us.new", "orders.*"} {
+		if err := mux.Handle(subj, handler(subj)); err != nil {
+			t.Fatalf("Error registering handler for %q: %v", subj, err)
+		}
+	}
+
+	check := func(subj, expected string) {
+		t.Helper()
+		nc.Publish(subj, nil)
+		select {
+		case got := <-routed:
+			if got != expected+" "+subj {
+				t.Fatalf("Expected %q to be routed to %q, got %q", subj, expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Message on %q not routed", subj)
+		}
+	}
+	check("orders.us.new", "orders.us.new")
+	check("orders.us.old", "orders.us.>")
+	check("orders.us.new.urgent", "orders.us.>")
+	check("orders.eu.new", "orders.*.new")
+	check("orders.eu", "orders.*")
+	check("orders.eu.old", "orders.>")
+
+	// Removing handlers falls back to less specific ones, then to the fallback.
+	if !mux.Remove("orders.us.new") || mux.Remove("orders.us.new") {
+		t.Fatal("Unexpected result removing handler")
+	}
+	check("orders.us.new", "orders.us.>")
+	mux.Remove("orders.>")
+	mux.SetFallback(handler("fallback"))
+	check("orders.eu.old", "fallback")
+
+	// Invalid handlers.
+	for _, subj := range []string{"orders..new", "orders", "other.>", ">", "orders.us new"} {
+		if err := mux.Handle(subj, handler(subj)); err != nats.ErrBadSubject {
+			t.Fatalf("Expected %v for %q, got %v", nats.ErrBadSubject, subj, err)
+		}
+	}
+	if err := mux.Handle("orders.*", handler("dup")); err != nats.ErrMuxHandlerExists {
+		t.Fatalf("Expected %v, got %v", nats.ErrMuxHandlerExists, err)
+	}
+	if _, err := nc.NewMux("orders..>"); err != nats.ErrBadSubject {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+	}
+
+	// A single subscription is used.
+	if n := nc.NumSubscriptions(); n != 1 {
+		t.Fatalf("Expected 1 subscription, got %v", n)
+	}
+}
+
+func TestMuxDrain(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	mux, err := nc.NewQueueMux("jobs.*", "workers")
+	if err != nil {
+		t.Fatalf("Error creating mux: %v", err)
+	}
+	received := make(chan bool, 100)
+	mux.Handle("jobs.slow", func(_ *nats.Msg) {
+		time.Sleep(10 * time.Millisecond)
+		received <- true
+	})
+	for i := 0; i < 10; i++ {
+		nc.Publish("jobs.slow", nil)
+	}
+	nc.Flush()
+
+	closed := make(chan bool)
+	nc.SetClosedHandler(func(_ *nats.Conn) { close(closed) })
+	if err := nc.Drain(); err != nil {
+		t.Fatalf("Error on drain: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection not closed after drain")
+	}
+	if n := len(received); n != 10 {
+		t.Fatalf("Expected all 10 messages to be processed, got %v", n)
+	}
+	if mux.Subscription().IsValid() {
+		t.Fatal("Expected subscription to be removed")
+	}
+}
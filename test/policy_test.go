@@ -0,0 +1,229 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestRequestPolicyRetries(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.SetRequestPolicy(nats.RequestPolicy{
+		MaxAttempts: 5,
+		Backoff:     50 * time.Millisecond,
+		Jitter:      0.5,
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// Start the responder after the first attempt failed with no responders.
+	nc2, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc2.Close()
+	time.AfterFunc(30*time.Millisecond, func() {
+		nc2.Subscribe("svc", func(m *nats.Msg) { m.Respond([]byte("ok")) })
+		nc2.Flush()
+	})
+
+	for _, request := range []func() (*nats.Msg, error){
+		func() (*nats.Msg, error) { return nc.Request("svc", nil, time.Second) },
+		func() (*nats.Msg, error) {
+			return nc.RequestWithContext(context.Background(), "svc", nil)
+		},
+	} {
+		resp, err := request()
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if string(resp.Data) != "ok" {
+			t.Fatalf("Unexpected response: %q", resp.Data)
+		}
+	}
+
+	// Attempts are exhausted, with backoff, if no responder shows up.
+	start := time.Now()
+	if _, err := nc.Request("nope", nil, time.Second); err != nats.ErrNoResponders {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Fatalf("Expected retries with backoff, took %v", elapsed)
+	}
+
+	// The policy also applies to encoded connections.
+	ec, err := nats.NewEncodedConn(nc, nats.DEFAULT_ENCODER)
+	if err != nil {
+		t.Fatalf("Unable to create encoded connection: %v", err)
+	}
+	var resp string
+	if err := ec.Request("svc", "hello", &resp, time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if err := ec.Request("nope", "hello", &resp, time.Second); err != nats.ErrNoResponders {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+	}
+
+	// Context errors are not retried.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := nc.RequestWithContext(ctx, "nope", nil); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestRequestPolicyCircuitBreaker(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.SetRequestPolicy(nats.RequestPolicy{
+		BreakerThreshold: 2,
+		BreakerCooldown:  100 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := nc.Request("svc", nil, time.Second); err != nats.ErrNoResponders {
+			t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+		}
+	}
+	if _, err := nc.Request("svc", nil, time.Second); err != nats.ErrCircuitOpen {
+		t.Fatalf("Expected %v, got %v", nats.ErrCircuitOpen, err)
+	}
+	// Other subjects are not affected.
+	if _, err := nc.Request("other", nil, time.Second); err != nats.ErrNoResponders {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+	}
+
+	// After the cooldown, a successful trial request closes the circuit.
+	nc.Subscribe("svc", func(m *nats.Msg) { m.Respond([]byte("ok")) })
+	nc.Flush()
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := nc.Request("svc", nil, time.Second); err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+	}
+
+	// Failures are forgotten after a cooldown without failure.
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(150 * time.Millisecond)
+		}
+		if _, err := nc.Request("spaced", nil, time.Second); err != nats.ErrNoResponders {
+			t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+		}
+	}
+}
+
+func TestRequestPolicyNotAppliedToJetStream(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.SetRequestPolicy(nats.RequestPolicy{
+		MaxAttempts: 3,
+		HedgeDelay:  10 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// A stream that never acknowledges.
+	var received int32
+	nc.Subscribe("foo", func(_ *nats.Msg) { atomic.AddInt32(&received, 1) })
+	nc.Flush()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Publish("foo", []byte("hello"), nats.AckWait(100*time.Millisecond)); err != nats.ErrTimeout {
+		t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+	}
+	// Neither retried nor hedged, which could store the message twice.
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&received); n != 1 {
+		t.Fatalf("Expected the message to be published once, got %v", n)
+	}
+}
+
+func TestRequestPolicyHedging(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.SetRequestPolicy(nats.RequestPolicy{
+		HedgeDelay: 50 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// The first request is slow to be processed, the hedged one is not.
+	var count int32
+	nc.Subscribe("svc", func(m *nats.Msg) {
+		n := atomic.AddInt32(&count, 1)
+		if n == 1 {
+			go func() {
+				time.Sleep(time.Second)
+				m.Respond([]byte("slow"))
+			}()
+			return
+		}
+		m.Respond([]byte("fast"))
+	})
+	nc.Flush()
+
+	start := time.Now()
+	resp, err := nc.Request("svc", nil, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if string(resp.Data) != "fast" {
+		t.Fatalf("Expected hedged response, got %q", resp.Data)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Hedged request took too long: %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Fatalf("Expected 2 requests, got %v", n)
+	}
+}
+
+func TestRequestPolicyInvalidArgs(t *testing.T) {
+	for _, p := range []nats.RequestPolicy{
+		{Backoff: -1},
+		{Jitter: 1.5},
+		{HedgeDelay: -time.Second},
+		{BreakerThreshold: -1},
+	} {
+		o := nats.GetDefaultOptions()
+		if err := nats.SetRequestPolicy(p)(&o); err != nats.ErrInvalidArg {
+			t.Fatalf("Expected %v for %+v, got %v", nats.ErrInvalidArg, p, err)
+		}
+	}
+}
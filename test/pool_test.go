@@ -0,0 +1,85 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestSubscriptionMsgPooling(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.SetMsgPooling(true); err != nil {
+		t.Fatalf("Error enabling message pooling: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		msg := nats.NewMsg("foo")
+		msg.Header.Set("Seq", fmt.Sprint(i))
+		msg.Data = []byte(fmt.Sprintf("msg-%d", i))
+		if err := nc.PublishMsg(msg); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	nc.Flush()
+	for i := 0; i < 10; i++ {
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message: %v", err)
+		}
+		if m.Subject != "foo" || string(m.Data) != fmt.Sprintf("msg-%d", i) || m.Header.Get("Seq") != fmt.Sprint(i) {
+			t.Fatalf("Unexpected message %d: %+v", i, m)
+		}
+		m.Release()
+	}
+
+	// Messages not coming from a pool are left as is.
+	m := &nats.Msg{Subject: "foo", Data: []byte("hello")}
+	m.Release()
+	if string(m.Data) != "hello" {
+		t.Fatalf("Unexpected data after release: %q", m.Data)
+	}
+
+	if err := sub.SetMsgPooling(false); err != nil {
+		t.Fatalf("Error disabling message pooling: %v", err)
+	}
+	nc.Publish("foo", []byte("hello"))
+	m, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+	m.Release()
+	if string(m.Data) != "hello" {
+		t.Fatalf("Unexpected data after release: %q", m.Data)
+	}
+
+	sub.Unsubscribe()
+	if err := sub.SetMsgPooling(true); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// testProxy is a minimal HTTP CONNECT or SOCKS5 proxy.
+type testProxy struct {
+	l       net.Listener
+	auth    string
+	tunnels int32
+}
+
+func runTestProxy(t *testing.T, socks bool, auth string) *testProxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on an ephemeral port: %v", err)
+	}
+	p := &testProxy{l: l, auth: auth}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if socks {
+				go p.serveSOCKS(conn)
+			} else {
+				go p.serveHTTP(conn)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *testProxy) addr() string { return p.l.Addr().String() }
+
+func (p *testProxy) tunnel(conn, dst net.Conn) {
+	atomic.AddInt32(&p.tunnels, 1)
+	go func() {
+		io.Copy(dst, conn)
+		dst.Close()
+	}()
+	io.Copy(conn, dst)
+	conn.Close()
+}
+
+func (p *testProxy) serveHTTP(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		conn.Close()
+		return
+	}
+	if p.auth != "" && req.Header.Get("Proxy-Authorization") != "Basic "+p.auth {
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		conn.Close()
+		return
+	}
+	dst, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		conn.Close()
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	p.tunnel(conn, dst)
+}
+
+func (p *testProxy) serveSOCKS(conn net.Conn) {
+	buf := make([]byte, 256)
+	// Greeting, no authentication.
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		conn.Close()
+		return
+	}
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{5, 0})
+	// Connect request, to a domain name or an IPv4 address.
+	io.ReadFull(conn, buf[:4])
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		n := int(buf[0])
+		io.ReadFull(conn, buf[:n])
+		host = string(buf[:n])
+	}
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	dst, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	p.tunnel(conn, dst)
+}
+
+func TestProxy(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	for _, test := range []struct {
+		name  string
+		socks bool
+		url   string
+	}{
+		{"http", false, "http://user:pwd@%s"},
+		{"socks5", true, "socks5://%s"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// "user:pwd" in base64.
+			p := runTestProxy(t, test.socks, "dXNlcjpwd2Q=")
+			defer p.l.Close()
+
+			nc, err := nats.Connect(s.ClientURL(), nats.Proxy(fmt.Sprintf(test.url, p.addr())))
+			if err != nil {
+				t.Fatalf("Error connecting to server: %v", err)
+			}
+			defer nc.Close()
+
+			sub, err := nc.SubscribeSync("foo")
+			if err != nil {
+				t.Fatalf("Error on subscribe: %v", err)
+			}
+			nc.Publish("foo", []byte("hello"))
+			if _, err := sub.NextMsg(2 * time.Second); err != nil {
+				t.Fatalf("Error receiving message: %v", err)
+			}
+			if n := atomic.LoadInt32(&p.tunnels); n != 1 {
+				t.Fatalf("Expected 1 tunnel, got %v", n)
+			}
+		})
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	p := runTestProxy(t, false, "dXNlcjpwd2Q=")
+	defer p.l.Close()
+
+	// Wrong credentials.
+	_, err := nats.Connect(s.ClientURL(), nats.Proxy(fmt.Sprintf("http://user:bad@%s", p.addr())))
+	if err == nil {
+		t.Fatal("Expected error with wrong proxy credentials")
+	}
+	if _, err := nats.Connect(s.ClientURL(), nats.Proxy("ftp://127.0.0.1")); err != nats.ErrProxyNotSupported {
+		t.Fatalf("Expected %v, got %v", nats.ErrProxyNotSupported, err)
+	}
+
+	// The proxy function can choose to connect directly.
+	var asked *url.URL
+	nc, err := nats.Connect(s.ClientURL(), nats.SetProxyFunc(func(server *url.URL) (*url.URL, error) {
+		asked = server
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	if asked == nil || asked.String() != s.ClientURL() {
+		t.Fatalf("Unexpected server given to the proxy function: %v", asked)
+	}
+}
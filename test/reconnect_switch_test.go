@@ -0,0 +1,177 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestSwitchServer(t *testing.T) {
+	s1 := RunServerOnPort(-1)
+	defer s1.Shutdown()
+	s2 := RunServerOnPort(-1)
+	defer s2.Shutdown()
+
+	derrs := make(chan error, 10)
+	nc, err := nats.Connect(s1.ClientURL(), nats.DontRandomize(),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) { derrs <- err }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	statuses := nc.StatusChanged(nats.CONNECTED)
+
+	if err := nc.SwitchServer(s2.ClientURL()); err != nil {
+		t.Fatalf("Error switching server: %v", err)
+	}
+	// Published while reconnecting, delivered once connected to s2.
+	if err := nc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	waitForStatus(t, statuses, nats.CONNECTED)
+	select {
+	case err := <-derrs:
+		if err != nats.ErrForcedReconnect {
+			t.Fatalf("Expected %v, got %v", nats.ErrForcedReconnect, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Disconnect handler was not invoked")
+	}
+	if u := nc.ConnectedUrl(); u != s2.ClientURL() {
+		t.Fatalf("Expected to be connected to %q, got %q", s2.ClientURL(), u)
+	}
+	if _, err := sub.NextMsg(2 * time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+	if n := len(nc.Servers()); n != 2 {
+		t.Fatalf("Expected 2 servers in the pool, got %v", n)
+	}
+
+	// The server we leave is tried last.
+	if err := nc.Reconnect(); err != nil {
+		t.Fatalf("Error reconnecting: %v", err)
+	}
+	waitForStatus(t, statuses, nats.CONNECTED)
+	if u := nc.ConnectedUrl(); u != s1.ClientURL() {
+		t.Fatalf("Expected to be connected to %q, got %q", s1.ClientURL(), u)
+	}
+	if nc.Reconnects != 2 {
+		t.Fatalf("Expected 2 reconnects, got %v", nc.Reconnects)
+	}
+
+	nc.Close()
+	if err := nc.Reconnect(); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
+func TestReconnectNotAllowed(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	if err := nc.Reconnect(); err != nats.ErrReconnectNotAllowed {
+		t.Fatalf("Expected %v, got %v", nats.ErrReconnectNotAllowed, err)
+	}
+	if err := nc.SwitchServer(s.ClientURL()); err != nats.ErrReconnectNotAllowed {
+		t.Fatalf("Expected %v, got %v", nats.ErrReconnectNotAllowed, err)
+	}
+	if !nc.IsConnected() {
+		t.Fatal("Expected to still be connected")
+	}
+}
+
+func TestLameDuckModeReconnect(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	// Fake server that enters lame duck mode once the client subscribed,
+	// and never closes the connection itself.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on an ephemeral port: %v", err)
+	}
+	defer l.Close()
+	left := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"ldm\"}\r\n"))
+		br := bufio.NewReader(conn)
+		// CONNECT and PING
+		br.ReadString('\n')
+		br.ReadString('\n')
+		conn.Write([]byte("PONG\r\n"))
+		if line, _ := br.ReadString('\n'); len(line) < 3 || line[:3] != "SUB" {
+			return
+		}
+		conn.Write([]byte("INFO {\"ldm\":true}\r\n"))
+		io.Copy(ioutil.Discard, br)
+		close(left)
+	}()
+
+	ldmURL := fmt.Sprintf("nats://%s", l.Addr())
+	nc, err := nats.Connect(ldmURL+","+s.ClientURL(),
+		nats.DontRandomize(),
+		nats.LameDuckModeReconnect(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	statuses := nc.StatusChanged(nats.CONNECTED)
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	select {
+	case <-left:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client did not leave the server in lame duck mode")
+	}
+	waitForStatus(t, statuses, nats.CONNECTED)
+	if u := nc.ConnectedUrl(); u != s.ClientURL() {
+		t.Fatalf("Expected to be connected to %q, got %q", s.ClientURL(), u)
+	}
+	nc.Publish("foo", []byte("hello"))
+	if _, err := sub.NextMsg(2 * time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+
+	if _, err := nats.Connect(s.ClientURL(), nats.LameDuckModeReconnect(-1)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func collectResponses(t *testing.T, ch <-chan *nats.Msg, timeout time.Duration) []string {
+	t.Helper()
+	var resps []string
+	tm := time.NewTimer(timeout)
+	defer tm.Stop()
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return resps
+			}
+			resps = append(resps, string(m.Data))
+		case <-tm.C:
+			t.Fatalf("Channel was not closed, got %v", resps)
+		}
+	}
+}
+
+func TestRequestMany(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	for i := 0; i < 3; i++ {
+		i := i
+		if _, err := nc.Subscribe("svc", func(m *nats.Msg) {
+			m.Respond([]byte(fmt.Sprintf("resp-%d", i)))
+		}); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	// A responder that streams multiple responses and ends with a sentinel.
+	if _, err := nc.Subscribe("stream", func(m *nats.Msg) {
+		for i := 0; i < 5; i++ {
+			m.Respond([]byte(fmt.Sprintf("part-%d", i)))
+		}
+		m.Respond(nil)
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Flush()
+
+	t.Run("max wait", func(t *testing.T) {
+		start := time.Now()
+		ch, err := nc.RequestMany("svc", nil, nats.RequestManyMaxWait(250*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if resps := collectResponses(t, ch, time.Second); len(resps) != 3 {
+			t.Fatalf("Expected 3 responses, got %v", resps)
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+			t.Fatalf("Request returned too early: %v", elapsed)
+		}
+	})
+
+	t.Run("max messages", func(t *testing.T) {
+		ch, err := nc.RequestMany("svc", nil, nats.RequestManyMaxMessages(2))
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if resps := collectResponses(t, ch, time.Second); len(resps) != 2 {
+			t.Fatalf("Expected 2 responses, got %v", resps)
+		}
+	})
+
+	t.Run("stall", func(t *testing.T) {
+		start := time.Now()
+		ch, err := nc.RequestMany("svc", nil, nats.RequestManyStall(50*time.Millisecond), nats.RequestManyMaxWait(5*time.Second))
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if resps := collectResponses(t, ch, time.Second); len(resps) != 3 {
+			t.Fatalf("Expected 3 responses, got %v", resps)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Stall did not terminate the request: %v", elapsed)
+		}
+	})
+
+	t.Run("sentinel", func(t *testing.T) {
+		ch, err := nc.RequestMany("stream", nil, nats.RequestManySentinel(nats.EmptySentinel), nats.RequestManyMaxWait(5*time.Second))
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		resps := collectResponses(t, ch, time.Second)
+		if len(resps) != 5 || resps[0] != "part-0" || resps[4] != "part-4" {
+			t.Fatalf("Unexpected responses: %v", resps)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		ch, err := nc.RequestManyWithContext(ctx, "svc", nil)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if resps := collectResponses(t, ch, time.Second); len(resps) != 3 {
+			t.Fatalf("Expected 3 responses, got %v", resps)
+		}
+	})
+
+	t.Run("no responders", func(t *testing.T) {
+		ch, err := nc.RequestMany("none", nil, nats.RequestManyMaxWait(5*time.Second))
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if resps := collectResponses(t, ch, time.Second); len(resps) != 0 {
+			t.Fatalf("Expected no response, got %v", resps)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		for _, opt := range []nats.RequestManyOpt{
+			nats.RequestManyMaxMessages(0),
+			nats.RequestManyMaxWait(-1),
+			nats.RequestManyStall(0),
+			nats.RequestManySentinel(nil),
+		} {
+			if _, err := nc.RequestMany("svc", nil, opt); err != nats.ErrInvalidArg {
+				t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+			}
+		}
+	})
+
+	// Single response requests are not affected and share
+	// the same response subscription.
+	if _, err := nc.Request("svc", nil, time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if n := nc.NumSubscriptions(); n != 5 {
+		t.Fatalf("Expected 5 subscriptions, got %v", n)
+	}
+}
+
+func TestRequestManyConnectionClosed(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	if _, err := nc.Subscribe("svc", func(m *nats.Msg) { m.Respond([]byte("ok")) }); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	ch, err := nc.RequestMany("svc", nil, nats.RequestManyMaxWait(time.Hour))
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("Did not get response")
+	}
+	nc.Close()
+	if resps := collectResponses(t, ch, time.Second); len(resps) != 0 {
+		t.Fatalf("Expected no response, got %v", resps)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestServerSelector(t *testing.T) {
+	s1 := RunServerOnPort(-1)
+	defer s1.Shutdown()
+	s2 := RunServerOnPort(-1)
+	defer s2.Shutdown()
+	s3 := RunServerOnPort(-1)
+	defer s3.Shutdown()
+
+	u2, _ := url.Parse(s2.ClientURL())
+	selected := make(chan []nats.PoolServer, 10)
+	selector := nats.ServerSelectorFunc(func(servers []nats.PoolServer) int {
+		selected <- servers
+		for i, s := range servers {
+			if s.URL.Host == u2.Host {
+				return i
+			}
+		}
+		return 0
+	})
+
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(s1.ClientURL()+","+s2.ClientURL()+","+s3.ClientURL(),
+		nats.SetServerSelector(selector),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	if nc.ConnectedUrl() != s2.ClientURL() {
+		t.Fatalf("Expected to be connected to %q, got %q", s2.ClientURL(), nc.ConnectedUrl())
+	}
+	// The selector is used to order the initial pool.
+	if servers := <-selected; len(servers) != 3 {
+		t.Fatalf("Expected 3 servers offered, got %+v", servers)
+	}
+
+	// On reconnect, the server that was lost is not offered.
+	s2.Shutdown()
+	select {
+	case <-rch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not reconnect")
+	}
+	var servers []nats.PoolServer
+	for len(selected) > 0 {
+		servers = <-selected
+	}
+	if len(servers) != 2 || servers[0].URL.Host == u2.Host || servers[1].URL.Host == u2.Host {
+		t.Fatalf("Unexpected servers offered: %+v", servers)
+	}
+	if url := nc.ConnectedUrl(); url != s1.ClientURL() && url != s3.ClientURL() {
+		t.Fatalf("Unexpected connected server %q", url)
+	}
+}
+
+func TestDiscoveredServersFilter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error on listen: %v", err)
+	}
+	defer l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("INFO {\"server_id\":\"server1\"}\r\n"))
+		buf := make([]byte, 0, 100)
+		b := make([]byte, 100)
+		for !bytes.Contains(buf, []byte("PING\r\n")) {
+			n, err := c.Read(b)
+			if err != nil {
+				return
+			}
+			buf = append(buf, b[:n]...)
+		}
+		c.Write([]byte(fmt.Sprintf("PONG\r\nINFO {\"server_id\":\"server1\",\"connect_urls\":[\"127.0.0.1:%d\", \"127.0.0.1:4223\", \"127.0.0.1:4224\"]}\r\n", addr.Port)))
+		for {
+			if _, err := c.Read(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	dch := make(chan bool, 1)
+	nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", addr.Port),
+		nats.DiscoveredServersFilter(func(url string) bool {
+			return url != "nats://127.0.0.1:4224"
+		}),
+		nats.DiscoveredServersHandler(func(_ *nats.Conn) { dch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	select {
+	case <-dch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Discovered servers handler not invoked")
+	}
+	ds := nc.DiscoveredServers()
+	if len(ds) != 1 || ds[0] != "nats://127.0.0.1:4223" {
+		t.Fatalf("Unexpected discovered servers: %v", ds)
+	}
+}
+
+func TestServerSelectors(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	servers := []nats.PoolServer{
+		{URL: parse("nats://a:4222")},
+		{URL: parse("nats://b:4222"), DidConnect: true, RTT: 5 * time.Millisecond, Cluster: "east", Tags: []string{"az:1"}},
+		{URL: parse("nats://c:4222"), DidConnect: true, RTT: time.Millisecond, Cluster: "west", Tags: []string{"az:2"}},
+		{URL: parse("nats://d:4222"), DidConnect: true, Failed: true, RTT: time.Microsecond, Cluster: "west", Tags: []string{"az:1"}},
+	}
+
+	for _, test := range []struct {
+		name     string
+		selector nats.ServerSelector
+		expected int
+	}{
+		{"rtt", nats.RTTSelector(), 2},
+		{"locality cluster", nats.LocalitySelector("east"), 1},
+		{"locality tags", nats.LocalitySelector("west", "az:2"), 2},
+		{"locality unknown", nats.LocalitySelector("north"), 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if i := test.selector.Select(servers); i != test.expected {
+				t.Fatalf("Expected server %v to be selected, got %v", test.expected, i)
+			}
+		})
+	}
+
+	t.Run("random", func(t *testing.T) {
+		rs := nats.RandomSelector()
+		seen := make(map[int]bool)
+		for i := 0; i < 100; i++ {
+			seen[rs.Select(servers)] = true
+		}
+		if len(seen) != 3 || seen[3] {
+			t.Fatalf("Unexpected selection: %v", seen)
+		}
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		spread := make(map[int]bool)
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("client-%d", i)
+			first := nats.ConsistentHashSelector(key).Select(servers)
+			if first == 3 {
+				t.Fatalf("Failed server should not be selected")
+			}
+			if again := nats.ConsistentHashSelector(key).Select(servers); again != first {
+				t.Fatalf("Expected same selection for %q, got %v and %v", key, first, again)
+			}
+			// Removing another server does not change the selection.
+			others := make([]nats.PoolServer, 0, len(servers))
+			for j, s := range servers {
+				if j != first && j != (first+1)%3 {
+					others = append(others, s)
+				}
+			}
+			others = append([]nats.PoolServer{servers[first]}, others...)
+			if i := nats.ConsistentHashSelector(key).Select(others); i != 0 {
+				t.Fatalf("Expected selection to be stable for %q", key)
+			}
+			spread[first] = true
+		}
+		if len(spread) < 2 {
+			t.Fatalf("Expected selections to be spread, got %v", spread)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/services"
+)
+
+func TestServices(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	cfg := services.Config{
+		Name:        "calc",
+		Version:     "1.0.0",
+		Description: "Calculator",
+		Endpoints: []services.Endpoint{
+			{
+				Name:    "echo",
+				Subject: "calc.echo",
+				Handler: func(r *services.Request) { r.Respond(r.Data) },
+			},
+			{
+				Name: "fail",
+				Handler: func(r *services.Request) {
+					if len(r.Data) > 0 {
+						r.Error(500, "", nil)
+						return
+					}
+					r.Error(400, "bad request", nil)
+				},
+			},
+		},
+	}
+	var svcs []*services.Service
+	for i := 0; i < 2; i++ {
+		svc, err := services.AddService(nc, cfg)
+		if err != nil {
+			t.Fatalf("Error adding service: %v", err)
+		}
+		defer svc.Stop()
+		svcs = append(svcs, svc)
+	}
+	other, err := services.AddService(nc, services.Config{
+		Name:      "other",
+		Version:   "0.1.0",
+		Endpoints: []services.Endpoint{{Name: "noop", Handler: func(r *services.Request) { r.Respond(nil) }}},
+	})
+	if err != nil {
+		t.Fatalf("Error adding service: %v", err)
+	}
+	defer other.Stop()
+
+	for i := 0; i < 4; i++ {
+		resp, err := nc.Request("calc.echo", []byte("hello"), time.Second)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if string(resp.Data) != "hello" {
+			t.Fatalf("Unexpected response: %q", resp.Data)
+		}
+	}
+	resp, err := nc.Request("fail", nil, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if resp.Header.Get(services.ErrorCodeHeader) != "400" || resp.Header.Get(services.ErrorHeader) != "bad request" {
+		t.Fatalf("Unexpected error headers: %+v", resp.Header)
+	}
+	// Errors without description are counted too.
+	resp, err = nc.Request("fail", []byte("x"), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if resp.Header.Get(services.ErrorCodeHeader) != "500" {
+		t.Fatalf("Unexpected error headers: %+v", resp.Header)
+	}
+
+	client := services.NewClient(nc)
+	pings, err := client.Ping("")
+	if err != nil {
+		t.Fatalf("Error on ping: %v", err)
+	}
+	if len(pings) != 3 {
+		t.Fatalf("Expected 3 ping responses, got %+v", pings)
+	}
+	pings, err = client.Ping("calc")
+	if err != nil {
+		t.Fatalf("Error on ping: %v", err)
+	}
+	if len(pings) != 2 || pings[0].Type != services.PingResponseType || pings[0].Version != "1.0.0" {
+		t.Fatalf("Unexpected ping responses: %+v", pings)
+	}
+
+	infos, err := client.Info("calc")
+	if err != nil {
+		t.Fatalf("Error on info: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Expected 2 info responses, got %+v", infos)
+	}
+	info := infos[0]
+	if info.Description != "Calculator" || len(info.Endpoints) != 2 ||
+		info.Endpoints[0].Subject != "calc.echo" || info.Endpoints[1].Subject != "fail" ||
+		info.Endpoints[0].QueueGroup != services.DefaultQueueGroup {
+		t.Fatalf("Unexpected info: %+v", info)
+	}
+
+	// Address a single instance.
+	id := svcs[0].ID()
+	resp, err = nc.Request(services.ControlSubject(services.StatsVerb, "calc", id), nil, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if len(resp.Data) == 0 {
+		t.Fatal("Expected stats response")
+	}
+
+	stats, err := client.Stats("calc")
+	if err != nil {
+		t.Fatalf("Error on stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 stats responses, got %+v", stats)
+	}
+	agg := services.AggregateStats(stats)
+	if len(agg) != 1 || agg[0].Name != "calc" || agg[0].Instances != 2 || len(agg[0].Endpoints) != 2 {
+		t.Fatalf("Unexpected aggregated stats: %+v", agg)
+	}
+	echo, fail := agg[0].Endpoints[0], agg[0].Endpoints[1]
+	if echo.NumRequests != 4 || echo.NumErrors != 0 {
+		t.Fatalf("Unexpected echo stats: %+v", echo)
+	}
+	if fail.NumRequests != 2 || fail.NumErrors != 2 || (fail.LastError != "400:bad request" && fail.LastError != "500:") {
+		t.Fatalf("Unexpected fail stats: %+v", fail)
+	}
+
+	// No more discovery responses once stopped.
+	for _, svc := range svcs {
+		if err := svc.Stop(); err != nil {
+			t.Fatalf("Error stopping service: %v", err)
+		}
+	}
+	if err := svcs[0].Stop(); err != services.ErrServiceStopped {
+		t.Fatalf("Expected %v, got %v", services.ErrServiceStopped, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pings, err := client.Ping("calc"); err != nil || len(pings) != 0 {
+		t.Fatalf("Expected no responses, got %+v (%v)", pings, err)
+	}
+}
+
+func TestServicesConfigValidation(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	h := func(r *services.Request) {}
+	for _, cfg := range []services.Config{
+		{Name: "", Version: "1.0.0", Endpoints: []services.Endpoint{{Name: "a", Handler: h}}},
+		{Name: "bad name", Version: "1.0.0", Endpoints: []services.Endpoint{{Name: "a", Handler: h}}},
+		{Name: "svc", Endpoints: []services.Endpoint{{Name: "a", Handler: h}}},
+		{Name: "svc", Version: "1.0.0"},
+		{Name: "svc", Version: "1.0.0", Endpoints: []services.Endpoint{{Name: "a"}}},
+		{Name: "svc", Version: "1.0.0", Endpoints: []services.Endpoint{{Name: "a", Handler: h}, {Name: "a", Handler: h}}},
+		{Name: "svc", Version: "1.0.0", Endpoints: []services.Endpoint{{Name: "a", Subject: "foo..bar", Handler: h}}},
+		{Name: "svc", Version: "1.0.0", Endpoints: []services.Endpoint{{Name: "a", Subject: "foo.>.bar", Handler: h}}},
+	} {
+		if _, err := services.AddService(nc, cfg); !errors.Is(err, services.ErrConfigValidation) {
+			t.Fatalf("Expected validation error for %+v, got %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func waitForStatus(t *testing.T, ch <-chan nats.Status, expected nats.Status) {
+	t.Helper()
+	select {
+	case s, ok := <-ch:
+		if !ok {
+			t.Fatalf("Channel closed while waiting for %v", expected)
+		}
+		if s != expected {
+			t.Fatalf("Expected status %v, got %v", expected, s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Did not get status %v", expected)
+	}
+}
+
+func TestStatusChanged(t *testing.T) {
+	s := RunServerOnPort(TEST_PORT)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.ReconnectWait(50*time.Millisecond), nats.MaxReconnects(-1))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	all := nc.StatusChanged()
+	closed := nc.StatusChanged(nats.CLOSED)
+	events := nc.Events(nats.DisconnectedEvent, nats.ReconnectedEvent, nats.ClosedEvent)
+
+	s.Shutdown()
+	waitForStatus(t, all, nats.RECONNECTING)
+
+	s = RunServerOnPort(TEST_PORT)
+	defer s.Shutdown()
+	waitForStatus(t, all, nats.CONNECTED)
+
+	nc.Close()
+	waitForStatus(t, all, nats.CLOSED)
+	waitForStatus(t, closed, nats.CLOSED)
+	if _, ok := <-all; ok {
+		t.Fatal("Expected channel to be closed")
+	}
+	if _, ok := <-closed; ok {
+		t.Fatal("Expected channel to be closed")
+	}
+
+	var types []nats.ConnEventType
+	for ev := range events {
+		if ev.Time.IsZero() {
+			t.Fatalf("Event time not set: %+v", ev)
+		}
+		if ev.Type == nats.DisconnectedEvent && ev.Status == nats.RECONNECTING && ev.Err == nil {
+			t.Fatalf("Expected an error on disconnect: %+v", ev)
+		}
+		if ev.Type == nats.ReconnectedEvent && ev.Server != s.ClientURL() {
+			t.Fatalf("Expected server %q, got %q", s.ClientURL(), ev.Server)
+		}
+		types = append(types, ev.Type)
+	}
+	expected := []nats.ConnEventType{nats.DisconnectedEvent, nats.ReconnectedEvent, nats.DisconnectedEvent, nats.ClosedEvent}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+	for i := range types {
+		if types[i] != expected[i] {
+			t.Fatalf("Expected events %v, got %v", expected, types)
+		}
+	}
+
+	// Listening on a closed connection returns a closed channel.
+	if _, ok := <-nc.StatusChanged(); ok {
+		t.Fatal("Expected channel to be closed")
+	}
+}
+
+func TestStatusListenerRemoval(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	ch := nc.StatusChanged()
+	ech := nc.Events()
+	nc.RemoveStatusListener(ch)
+	nc.RemoveEventListener(ech)
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected channel to be closed")
+	}
+	if _, ok := <-ech; ok {
+		t.Fatal("Expected channel to be closed")
+	}
+
+	// Draining reports both draining statuses.
+	ch = nc.StatusChanged(nats.DRAINING_SUBS, nats.DRAINING_PUBS)
+	if err := nc.Drain(); err != nil {
+		t.Fatalf("Error draining: %v", err)
+	}
+	waitForStatus(t, ch, nats.DRAINING_SUBS)
+	waitForStatus(t, ch, nats.DRAINING_PUBS)
+}
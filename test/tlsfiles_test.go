@@ -0,0 +1,190 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// writeCert writes the PEM files of a new certificate for 127.0.0.1,
+// signed by the given CA or self-signed, and returns the certificate.
+func writeCert(t *testing.T, certFile, keyFile string, ca *tls.Certificate) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: certFile},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return &cert
+}
+
+func TestWatchClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-tls")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := writeCert(t, caFile, filepath.Join(dir, "ca-key.pem"), nil)
+	serverCert := writeCert(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), ca)
+	clientCert := writeCert(t, certFile, keyFile, ca)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on an ephemeral port: %v", err)
+	}
+	defer l.Close()
+
+	// Fake TLS server reporting the client certificate of each connection.
+	peers := make(chan *x509.Certificate, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Write([]byte("INFO {\"server_id\":\"tls\",\"tls_required\":true}\r\n"))
+				tconn := tls.Server(conn, &tls.Config{
+					Certificates: []tls.Certificate{*serverCert},
+					ClientAuth:   tls.RequireAnyClientCert,
+				})
+				if err := tconn.Handshake(); err != nil {
+					return
+				}
+				peers <- tconn.ConnectionState().PeerCertificates[0]
+				br := bufio.NewReader(tconn)
+				// CONNECT and PING
+				br.ReadString('\n')
+				br.ReadString('\n')
+				tconn.Write([]byte("PONG\r\n"))
+				io.Copy(ioutil.Discard, br)
+			}(conn)
+		}
+	}()
+
+	errCh := make(chan error, 4)
+	nc, err := nats.Connect(fmt.Sprintf("tls://%s", l.Addr()),
+		nats.WatchClientCert(certFile, keyFile),
+		nats.WatchRootCAs(caFile),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			errCh <- err
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	statuses := nc.StatusChanged(nats.CONNECTED)
+
+	checkPeer := func(expected *tls.Certificate) {
+		t.Helper()
+		select {
+		case cert := <-peers:
+			if !bytes.Equal(cert.Raw, expected.Leaf.Raw) {
+				t.Fatalf("Unexpected client certificate %v", cert.Subject)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Did not get a TLS connection")
+		}
+	}
+	checkPeer(clientCert)
+
+	// Rotate the client certificate.
+	newCert := writeCert(t, certFile, keyFile, ca)
+	if err := nc.Reconnect(); err != nil {
+		t.Fatalf("Error reconnecting: %v", err)
+	}
+	checkPeer(newCert)
+	waitForStatus(t, statuses, nats.CONNECTED)
+
+	// A broken file is reported, and the previous certificate used.
+	if err := ioutil.WriteFile(certFile, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	if err := nc.Reconnect(); err != nil {
+		t.Fatalf("Error reconnecting: %v", err)
+	}
+	checkPeer(newCert)
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reload error was not reported")
+	}
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.WatchClientCert("missing.pem", "missing.pem")); err == nil {
+		t.Fatal("Expected error with missing certificate files")
+	}
+}
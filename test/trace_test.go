@@ -0,0 +1,228 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.SetTracer(nats.W3CTracer()))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	tc := nats.NewTraceContext()
+	tc.TraceState = "vendor=value"
+	ctx := nats.ContextWithTraceContext(context.Background(), tc)
+
+	// Publish with a context, and extract on a synchronous subscription.
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := nc.PublishMsgWithContext(ctx, &nats.Msg{Subject: "foo", Data: []byte("hello")}); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if got := msg.Header.Get(nats.TraceParentHdr); got != tc.TraceParent {
+		t.Fatalf("Expected traceparent %q, got %q", tc.TraceParent, got)
+	}
+	got, ok := nats.TraceContextFromContext(msg.Context())
+	if !ok || got != tc {
+		t.Fatalf("Expected trace context %+v, got %+v", tc, got)
+	}
+
+	// Messages published without context do not carry trace context.
+	nc.Publish("foo", []byte("hello"))
+	msg, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if _, ok := nats.TraceContextFromContext(msg.Context()); ok || len(msg.Header) != 0 {
+		t.Fatalf("Unexpected trace context: %+v", msg.Header)
+	}
+
+	// Request/reply: the responder sees the trace context and
+	// propagates it back in the reply.
+	for _, respond := range []func(m *nats.Msg){
+		func(m *nats.Msg) { m.Respond([]byte("ok")) },
+		func(m *nats.Msg) { m.RespondMsg(&nats.Msg{Data: []byte("ok")}) },
+	} {
+		respond := respond
+		seen := make(chan nats.TraceContext, 1)
+		rsub, err := nc.Subscribe("svc", func(m *nats.Msg) {
+			tc, _ := nats.TraceContextFromContext(m.Context())
+			seen <- tc
+			respond(m)
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		resp, err := nc.RequestWithContext(ctx, "svc", nil)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if got := <-seen; got != tc {
+			t.Fatalf("Expected responder trace context %+v, got %+v", tc, got)
+		}
+		if got, _ := nats.TraceContextFromContext(resp.Context()); got != tc {
+			t.Fatalf("Expected reply trace context %+v, got %+v", tc, got)
+		}
+		rsub.Unsubscribe()
+	}
+}
+
+type testTracer struct {
+	injected  chan context.Context
+	extracted chan nats.Header
+}
+
+type testTracerKey struct{}
+
+func (tt *testTracer) Inject(ctx context.Context, h nats.Header) {
+	tt.injected <- ctx
+	if v, ok := ctx.Value(testTracerKey{}).(string); ok {
+		h.Set("X-Span", v)
+	}
+}
+
+func (tt *testTracer) Extract(ctx context.Context, h nats.Header) context.Context {
+	tt.extracted <- h
+	return context.WithValue(ctx, testTracerKey{}, h.Get("X-Span"))
+}
+
+func TestCustomTracer(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	tt := &testTracer{
+		injected:  make(chan context.Context, 10),
+		extracted: make(chan nats.Header, 10),
+	}
+	nc, err := nats.Connect(s.ClientURL(), nats.SetTracer(tt))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	// Inject is invoked with a background context for regular publish.
+	nc.Publish("foo", []byte("hello"))
+	select {
+	case ctx := <-tt.injected:
+		if ctx != context.Background() {
+			t.Fatalf("Expected background context, got %v", ctx)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Inject was not invoked")
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if len(msg.Header) != 0 {
+		t.Fatalf("Unexpected headers: %+v", msg.Header)
+	}
+
+	ctx := context.WithValue(context.Background(), testTracerKey{}, "span-1")
+	nc.PublishMsgWithContext(ctx, nats.NewMsg("foo"))
+	<-tt.injected
+	msg, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	// Extraction is done lazily, on each call, so that
+	// goroutines can share the message.
+	select {
+	case <-tt.extracted:
+		t.Fatal("Extract should not have been invoked yet")
+	default:
+	}
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			if v := msg.Context().Value(testTracerKey{}); v != "span-1" {
+				t.Errorf("Unexpected context value: %v", v)
+			}
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+	if n := len(tt.extracted); n != 2 {
+		t.Fatalf("Expected Extract to be invoked twice, got %v", n)
+	}
+
+	// The context is not kept by the message published with it.
+	pm := nats.NewMsg("foo")
+	nc.PublishMsgWithContext(ctx, pm)
+	if ctx := <-tt.injected; ctx.Value(testTracerKey{}) != "span-1" {
+		t.Fatalf("Unexpected injected context: %v", ctx)
+	}
+	nc.PublishMsg(pm)
+	if ctx := <-tt.injected; ctx != context.Background() {
+		t.Fatalf("Expected background context, got %v", ctx)
+	}
+
+	if err := nc.PublishMsgWithContext(nil, nats.NewMsg("foo")); err != nats.ErrInvalidContext {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidContext, err)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	traceID, parentID, flags, err := nats.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID != "00f067aa0ba902b7" || flags != "01" {
+		t.Fatalf("Unexpected result: %q %q %q", traceID, parentID, flags)
+	}
+	// Future versions may add fields.
+	if _, _, _, err := nats.ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, _, err := nats.ParseTraceParent(nats.NewTraceContext().TraceParent); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, tp := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, _, _, err := nats.ParseTraceParent(tp); err != nats.ErrInvalidTraceParent {
+			t.Fatalf("Expected error for %q, got %v", tp, err)
+		}
+	}
+}
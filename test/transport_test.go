@@ -0,0 +1,127 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// serveFakeConn answers the PINGs of the client until the connection
+// is closed. Writes do not block reads, as required with net.Pipe.
+func serveFakeConn(conn net.Conn) {
+	defer conn.Close()
+	out := make(chan string, 16)
+	defer close(out)
+	go func() {
+		for s := range out {
+			conn.Write([]byte(s))
+		}
+	}()
+	out <- "INFO {\"server_id\":\"fake\"}\r\n"
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(line, "PING") {
+			out <- "PONG\r\n"
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-unix")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "nats.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Could not listen on unix socket: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeConn(conn)
+		}
+	}()
+
+	nc, err := nats.Connect("unix://" + sock)
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	if url := nc.ConnectedUrl(); url != "unix://"+sock {
+		t.Fatalf("Unexpected connected URL: %q", url)
+	}
+	if servers := nc.Servers(); len(servers) != 1 || servers[0] != "unix://"+sock {
+		t.Fatalf("Unexpected servers: %v", servers)
+	}
+
+	if _, err := nats.Connect("unix://"); err != nats.ErrInvalidUnixURL {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidUnixURL, err)
+	}
+}
+
+func TestInProcessServer(t *testing.T) {
+	var conns int32
+	provider := nats.InProcessConnFunc(func() (net.Conn, error) {
+		atomic.AddInt32(&conns, 1)
+		cli, srv := net.Pipe()
+		go serveFakeConn(srv)
+		return cli, nil
+	})
+	nc, err := nats.Connect("nats://no.such.host:4222",
+		nats.InProcessServer(provider),
+		nats.ReconnectWait(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+	statuses := nc.StatusChanged(nats.CONNECTED)
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+
+	// Reconnecting gets a new connection from the provider.
+	if err := nc.Reconnect(); err != nil {
+		t.Fatalf("Error reconnecting: %v", err)
+	}
+	waitForStatus(t, statuses, nats.CONNECTED)
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Fatalf("Expected 2 connections, got %v", n)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// WatchClientCert is like ClientCert, but the certificate and key files
+// are reloaded when they change, so that they can be rotated without
+// restarting the application. The files are checked before each TLS
+// handshake. If they can not be reloaded, the error is reported to the
+// async error handler and the previous certificate is used.
+func WatchClientCert(certFile, keyFile string) Option {
+	return func(o *Options) error {
+		if _, err := loadClientCert(certFile, keyFile); err != nil {
+			return err
+		}
+		if o.TLSConfig == nil {
+			o.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		o.ClientCertFile = certFile
+		o.ClientKeyFile = keyFile
+		o.Secure = true
+		return nil
+	}
+}
+
+// WatchRootCAs is like RootCAs, but the files are reloaded when they
+// change, like with WatchClientCert.
+func WatchRootCAs(file ...string) Option {
+	return func(o *Options) error {
+		if _, err := loadRootCAs(file); err != nil {
+			return err
+		}
+		if o.TLSConfig == nil {
+			o.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		o.RootCAFiles = file
+		o.Secure = true
+		return nil
+	}
+}
+
+func loadClientCert(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("nats: error loading client certificate: %v", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("nats: error parsing client certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+func loadRootCAs(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		rootPEM, err := ioutil.ReadFile(f)
+		if err != nil || rootPEM == nil {
+			return nil, fmt.Errorf("nats: error loading or parsing rootCA file: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(rootPEM) {
+			return nil, fmt.Errorf("nats: failed to parse root certificate from %q", f)
+		}
+	}
+	return pool, nil
+}
+
+// tlsFiles holds the TLS material last loaded from the files, and
+// the state of the files at that time.
+type tlsFiles struct {
+	cert     *tls.Certificate
+	certStat []fileStat
+	pool     *x509.CertPool
+	poolStat []fileStat
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(files ...string) ([]fileStat, error) {
+	stats := make([]fileStat, 0, len(files))
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, fmt.Errorf("nats: error checking TLS file: %v", err)
+		}
+		stats = append(stats, fileStat{fi.ModTime(), fi.Size()})
+	}
+	return stats, nil
+}
+
+func sameStats(a, b []fileStat) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// applyTLSFiles sets the client certificate and root CAs loaded from
+// the files to the configuration of a handshake, reloading the files
+// if they changed. An error is returned only if the files were never
+// loaded, reload errors are reported to the async error handler.
+// Lock should be held.
+func (nc *Conn) applyTLSFiles(config *tls.Config) error {
+	o := &nc.Opts
+	if o.ClientCertFile == _EMPTY_ && len(o.RootCAFiles) == 0 {
+		return nil
+	}
+	if nc.tlsFiles == nil {
+		nc.tlsFiles = &tlsFiles{}
+	}
+	tf := nc.tlsFiles
+	if o.ClientCertFile != _EMPTY_ {
+		stat, err := statFiles(o.ClientCertFile, o.ClientKeyFile)
+		if err == nil && (tf.cert == nil || !sameStats(stat, tf.certStat)) {
+			var cert *tls.Certificate
+			if cert, err = loadClientCert(o.ClientCertFile, o.ClientKeyFile); err == nil {
+				tf.cert, tf.certStat = cert, stat
+			}
+		}
+		if err != nil {
+			if tf.cert == nil {
+				return err
+			}
+			nc.asyncError(nil, err)
+		}
+		cert := tf.cert
+		config.Certificates = nil
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
+	if len(o.RootCAFiles) > 0 {
+		stat, err := statFiles(o.RootCAFiles...)
+		if err == nil && (tf.pool == nil || !sameStats(stat, tf.poolStat)) {
+			var pool *x509.CertPool
+			if pool, err = loadRootCAs(o.RootCAFiles); err == nil {
+				tf.pool, tf.poolStat = pool, stat
+			}
+		}
+		if err != nil {
+			if tf.pool == nil {
+				return err
+			}
+			nc.asyncError(nil, err)
+		}
+		config.RootCAs = tf.pool
+	}
+	return nil
+}
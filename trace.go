@@ -0,0 +1,210 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Header names defined by the W3C Trace Context specification.
+const (
+	TraceParentHdr = "traceparent"
+	TraceStateHdr  = "tracestate"
+)
+
+var ErrInvalidTraceParent = errors.New("nats: invalid traceparent")
+
+// Tracer propagates trace context through message headers.
+//
+// When a Tracer is set on a connection, Inject is invoked for every message
+// published, with the context given to the publish call (for instance to
+// PublishMsgWithContext, RequestWithContext, or the context of the request
+// message for Respond and RespondMsg), or context.Background() otherwise.
+// Extract is invoked to build the context returned by Msg.Context() for
+// received messages.
+//
+// Inject is only invoked if the server supports headers.
+type Tracer interface {
+	// Inject adds the trace context found in ctx to the headers.
+	Inject(ctx context.Context, h Header)
+	// Extract returns a copy of ctx with the trace context found in the headers.
+	Extract(ctx context.Context, h Header) context.Context
+}
+
+// SetTracer is an Option to set the Tracer used to propagate
+// trace context through message headers.
+func SetTracer(tracer Tracer) Option {
+	return func(o *Options) error {
+		o.Tracer = tracer
+		return nil
+	}
+}
+
+// TraceContext holds the values of the W3C `traceparent`
+// and `tracestate` headers.
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+// NewTraceContext returns a TraceContext with a random trace and parent
+// identifier, and the sampled flag set.
+func NewTraceContext() TraceContext {
+	var ids [24]byte
+	rand.Read(ids[:])
+	return TraceContext{TraceParent: "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"}
+}
+
+// ParseTraceParent validates a `traceparent` header value and returns its
+// trace identifier, parent identifier and trace flags.
+func ParseTraceParent(tp string) (traceID, parentID, flags string, err error) {
+	// version-traceid-parentid-flags
+	if len(tp) < 55 || (len(tp) > 55 && tp[55] != '-') {
+		return _EMPTY_, _EMPTY_, _EMPTY_, ErrInvalidTraceParent
+	}
+	parts := strings.Split(tp[:55], "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return _EMPTY_, _EMPTY_, _EMPTY_, ErrInvalidTraceParent
+	}
+	for _, p := range parts {
+		if !isLowerHex(p) {
+			return _EMPTY_, _EMPTY_, _EMPTY_, ErrInvalidTraceParent
+		}
+	}
+	// Version ff is forbidden, and only version 00 has a fixed length.
+	if parts[0] == "ff" || (parts[0] == "00" && len(tp) != 55) {
+		return _EMPTY_, _EMPTY_, _EMPTY_, ErrInvalidTraceParent
+	}
+	if strings.Trim(parts[1], "0") == _EMPTY_ || strings.Trim(parts[2], "0") == _EMPTY_ {
+		return _EMPTY_, _EMPTY_, _EMPTY_, ErrInvalidTraceParent
+	}
+	return parts[1], parts[2], parts[3], nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx carrying the trace context,
+// which is injected in the headers of messages published with that context
+// when the W3CTracer is used.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context carried by ctx, if any.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// W3CTracer returns a Tracer that propagates the trace context stored with
+// ContextWithTraceContext as W3C `traceparent` and `tracestate` headers.
+// It can be used as is to forward trace context across services, or
+// as a reference to bridge to a tracing library.
+func W3CTracer() Tracer {
+	return w3cTracer{}
+}
+
+type w3cTracer struct{}
+
+func (w3cTracer) Inject(ctx context.Context, h Header) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	h.Set(TraceParentHdr, tc.TraceParent)
+	if tc.TraceState != _EMPTY_ {
+		h.Set(TraceStateHdr, tc.TraceState)
+	} else {
+		h.Del(TraceStateHdr)
+	}
+}
+
+func (w3cTracer) Extract(ctx context.Context, h Header) context.Context {
+	tp := traceHeader(h, TraceParentHdr)
+	if _, _, _, err := ParseTraceParent(tp); err != nil {
+		return ctx
+	}
+	tc := TraceContext{TraceParent: tp}
+	// Multiple tracestate headers are allowed and must be combined.
+	if ts := traceHeaderValues(h, TraceStateHdr); len(ts) > 0 {
+		tc.TraceState = strings.Join(ts, ",")
+	}
+	return ContextWithTraceContext(ctx, tc)
+}
+
+// Headers are case-sensitive, but peers may have used the canonical
+// MIME form of the W3C header names.
+var traceCanonicalHdrs = map[string]string{
+	TraceParentHdr: "Traceparent",
+	TraceStateHdr:  "Tracestate",
+}
+
+func traceHeaderValues(h Header, key string) []string {
+	if v := h.Values(key); len(v) > 0 {
+		return v
+	}
+	return h.Values(traceCanonicalHdrs[key])
+}
+
+func traceHeader(h Header, key string) string {
+	if v := traceHeaderValues(h, key); len(v) > 0 {
+		return v[0]
+	}
+	return _EMPTY_
+}
+
+// Context returns the context of the message, which is never nil. For a
+// received message, and if a Tracer is set on the connection, the context
+// carries the trace context extracted from the message headers, on each
+// call, so that the message can be shared by goroutines.
+func (m *Msg) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	ctx := context.Background()
+	if m.Sub != nil {
+		m.Sub.mu.Lock()
+		nc := m.Sub.conn
+		m.Sub.mu.Unlock()
+		if nc != nil && nc.Opts.Tracer != nil {
+			ctx = nc.Opts.Tracer.Extract(ctx, m.Header)
+		}
+	}
+	return ctx
+}
+
+// injectTraceContext is the outermost publish interceptor when
+// a Tracer is set.
+func (nc *Conn) injectTraceContext(m *Msg, next PublishFunc) error {
+	if nc.info.Headers {
+		if m.Header == nil {
+			m.Header = Header{}
+		}
+		nc.Opts.Tracer.Inject(m.Context(), m.Header)
+	}
+	return next(m)
+}
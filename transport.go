@@ -0,0 +1,72 @@
+// Copyright 2022 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"net"
+	"net/url"
+)
+
+// Scheme of the URLs of servers listening on a unix domain socket,
+// for instance "unix:///var/run/nats.sock".
+const unixScheme = "unix"
+
+var ErrInvalidUnixURL = errors.New("nats: invalid unix socket URL")
+
+// InProcessConnProvider provides the connections to a server running in
+// the same process, for instance an embedded server.
+type InProcessConnProvider interface {
+	// InProcessConn returns a new connection to the server. It is called
+	// for the initial connection and for each reconnect attempt.
+	InProcessConn() (net.Conn, error)
+}
+
+// InProcessConnFunc is an adapter to use a function as an
+// InProcessConnProvider.
+type InProcessConnFunc func() (net.Conn, error)
+
+// InProcessConn calls f().
+func (f InProcessConnFunc) InProcessConn() (net.Conn, error) {
+	return f()
+}
+
+// InProcessServer is an Option to get the connections to the server from
+// the given provider instead of dialing the server URLs. Reconnections
+// work as usual, a new connection being requested for each attempt.
+func InProcessServer(server InProcessConnProvider) Option {
+	return func(o *Options) error {
+		o.InProcessServer = server
+		return nil
+	}
+}
+
+func isUnixScheme(u *url.URL) bool {
+	return u.Scheme == unixScheme
+}
+
+// unixSocketPath returns the path of the socket of a unix URL. The path
+// is relative if the URL has a host, as in "unix://dir/nats.sock".
+func unixSocketPath(u *url.URL) string {
+	return u.Host + u.Path
+}
+
+// serverAddr returns the address of the server of the URL, that is the
+// host and port, or the socket path for unix URLs.
+func serverAddr(u *url.URL) string {
+	if isUnixScheme(u) {
+		return unixSocketPath(u)
+	}
+	return u.Host
+}
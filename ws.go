@@ -68,6 +68,12 @@ const (
 	wsPMCSrvNoCtx       = "server_no_context_takeover"
 	wsPMCCliNoCtx       = "client_no_context_takeover"
 	wsPMCReqHeaderValue = wsPMCExtension + "; " + wsPMCSrvNoCtx + "; " + wsPMCCliNoCtx
+	// Offer when the client keeps its compression context between messages.
+	wsPMCReqCtxHeaderValue = wsPMCExtension + "; " + wsPMCSrvNoCtx
+
+	// Header used to ask the server not to require masked frames.
+	wsNoMaskingHeader = "Nats-No-Masking"
+	wsNoMaskingValue  = "true"
 )
 
 // From https://tools.ietf.org/html/rfc6455#section-1.3
@@ -92,13 +98,18 @@ type wsDecompressor struct {
 }
 
 type websocketWriter struct {
-	w          io.Writer
-	compress   bool
-	compressor *flate.Writer
-	ctrlFrames [][]byte // pending frames that should be sent at the next Write()
-	cm         []byte   // close message that needs to be sent when everything else has been sent
-	cmDone     bool     // a close message has been added or sent (never going back to false)
-	noMoreSend bool     // if true, even if there is a Write() call, we should not send anything
+	w           io.Writer
+	compress    bool
+	compressor  *flate.Writer
+	level       int          // compression level
+	ctxTakeover bool         // keep the compression context between messages
+	cbuf        bytes.Buffer // compressed data, when keeping the context
+	noMasking   bool         // the server accepted unmasked frames
+	maxFrame    int          // maximum payload of a frame, 0 for unlimited
+	ctrlFrames  [][]byte     // pending frames that should be sent at the next Write()
+	cm          []byte       // close message that needs to be sent when everything else has been sent
+	cmDone      bool         // a close message has been added or sent (never going back to false)
+	noMoreSend  bool         // if true, even if there is a Write() call, we should not send anything
 }
 
 func (d *wsDecompressor) Read(dst []byte) (int, error) {
@@ -434,24 +445,32 @@ func (w *websocketWriter) Write(p []byte) (int, error) {
 	// We will end with checking for need to send close message.
 	if len(p) > 0 {
 		if w.compress {
-			buf := &bytes.Buffer{}
-			if w.compressor == nil {
-				w.compressor, _ = flate.NewWriter(buf, flate.BestSpeed)
-			} else {
-				w.compressor.Reset(buf)
-			}
-			w.compressor.Write(p)
-			w.compressor.Close()
-			b := buf.Bytes()
-			p = b[:len(b)-4]
+			p = w.deflate(p)
 		}
-		fh, key := wsCreateFrameHeader(w.compress, wsBinaryMessage, len(p))
-		wsMaskBuf(key, p)
-		n, err = w.w.Write(fh)
-		total += n
-		if err == nil {
-			n, err = w.w.Write(p)
+		// Split the payload in frames of at most maxFrame bytes, only the
+		// first one has the message type and compression flag.
+		frameType := wsBinaryMessage
+		compressed := w.compress
+		for len(p) > 0 && err == nil {
+			fp := p
+			if w.maxFrame > 0 && len(fp) > w.maxFrame {
+				fp = fp[:w.maxFrame]
+			}
+			p = p[len(fp):]
+			fh, key := wsCreateFrameHeader(!w.noMasking, compressed, frameType, len(fp))
+			if len(p) > 0 {
+				fh[0] &^= wsFinalBit
+			}
+			frameType, compressed = wsContinuationFrame, false
+			if key != nil {
+				wsMaskBuf(key, fp)
+			}
+			n, err = w.w.Write(fh)
 			total += n
+			if err == nil {
+				n, err = w.w.Write(fp)
+				total += n
+			}
 		}
 	}
 	if err == nil && w.cm != nil {
@@ -461,6 +480,36 @@ func (w *websocketWriter) Write(p []byte) (int, error) {
 	return total, err
 }
 
+// deflate returns the compressed payload, without the trailing
+// 0x00 0x00 0xff 0xff as per RFC 7692.
+func (w *websocketWriter) deflate(p []byte) []byte {
+	level := w.level
+	if level == 0 {
+		level = flate.BestSpeed
+	}
+	if w.ctxTakeover {
+		// Keep the compressor, and so the context, for the next messages.
+		w.cbuf.Reset()
+		if w.compressor == nil {
+			w.compressor, _ = flate.NewWriter(&w.cbuf, level)
+		}
+		w.compressor.Write(p)
+		w.compressor.Flush()
+		b := w.cbuf.Bytes()
+		return b[:len(b)-4]
+	}
+	buf := &bytes.Buffer{}
+	if w.compressor == nil {
+		w.compressor, _ = flate.NewWriter(buf, level)
+	} else {
+		w.compressor.Reset(buf)
+	}
+	w.compressor.Write(p)
+	w.compressor.Close()
+	b := buf.Bytes()
+	return b[:len(b)-4]
+}
+
 func (w *websocketWriter) writeCtrlFrames() (int, error) {
 	var (
 		n     int
@@ -498,20 +547,24 @@ func wsMaskBuf(key, buf []byte) {
 
 // Create the frame header.
 // Encodes the frame type and optional compression flag, and the size of the payload.
-func wsCreateFrameHeader(compressed bool, frameType wsOpCode, l int) ([]byte, []byte) {
+// The returned masking key is nil if the frame is not masked.
+func wsCreateFrameHeader(masked, compressed bool, frameType wsOpCode, l int) ([]byte, []byte) {
 	fh := make([]byte, wsMaxFrameHeaderSize)
-	n, key := wsFillFrameHeader(fh, compressed, frameType, l)
+	n, key := wsFillFrameHeader(fh, masked, compressed, frameType, l)
 	return fh[:n], key
 }
 
-func wsFillFrameHeader(fh []byte, compressed bool, frameType wsOpCode, l int) (int, []byte) {
+func wsFillFrameHeader(fh []byte, masked, compressed bool, frameType wsOpCode, l int) (int, []byte) {
 	var n int
 	b := byte(frameType)
 	b |= wsFinalBit
 	if compressed {
 		b |= wsRsv1Bit
 	}
-	b1 := byte(wsMaskBit)
+	var b1 byte
+	if masked {
+		b1 = wsMaskBit
+	}
 	switch {
 	case l <= 125:
 		n = 2
@@ -528,6 +581,9 @@ func wsFillFrameHeader(fh []byte, compressed bool, frameType wsOpCode, l int) (i
 		fh[1] = b1 | 127
 		binary.BigEndian.PutUint64(fh[2:], uint64(l))
 	}
+	if !masked {
+		return n, nil
+	}
 	var key []byte
 	var keyBuf [4]byte
 	if _, err := io.ReadFull(rand.Reader, keyBuf[:4]); err != nil {
@@ -578,12 +634,32 @@ func (nc *Conn) wsInitHandshake(u *url.URL) error {
 		return err
 	}
 
+	for k, v := range nc.Opts.WebSocketHeaders {
+		req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	if nc.Opts.WebSocketRequestCB != nil {
+		if err := nc.Opts.WebSocketRequestCB(req); err != nil {
+			return err
+		}
+	}
+	// These can not be replaced by the user.
+	req.Method = "GET"
+	req.Header.Del("Sec-WebSocket-Extensions")
 	req.Header["Upgrade"] = []string{"websocket"}
 	req.Header["Connection"] = []string{"Upgrade"}
 	req.Header["Sec-WebSocket-Key"] = []string{wsKey}
 	req.Header["Sec-WebSocket-Version"] = []string{"13"}
 	if compress {
-		req.Header.Add("Sec-WebSocket-Extensions", wsPMCReqHeaderValue)
+		if nc.Opts.CompressionContextTakeover {
+			req.Header.Add("Sec-WebSocket-Extensions", wsPMCReqCtxHeaderValue)
+		} else {
+			req.Header.Add("Sec-WebSocket-Extensions", wsPMCReqHeaderValue)
+		}
+	}
+	if nc.Opts.WebSocketNoMasking {
+		req.Header.Set(wsNoMaskingHeader, wsNoMaskingValue)
+	} else {
+		req.Header.Del(wsNoMaskingHeader)
 	}
 	if err := req.Write(nc.conn); err != nil {
 		return err
@@ -594,6 +670,9 @@ func (nc *Conn) wsInitHandshake(u *url.URL) error {
 	br := bufio.NewReaderSize(nc.conn, 4096)
 	nc.conn.SetReadDeadline(time.Now().Add(nc.Opts.Timeout))
 	resp, err = http.ReadResponse(br, req)
+	if err == nil && nc.Opts.WebSocketResponseCB != nil {
+		err = nc.Opts.WebSocketResponseCB(resp)
+	}
 	if err == nil &&
 		(resp.StatusCode != 101 ||
 			!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
@@ -603,16 +682,20 @@ func (nc *Conn) wsInitHandshake(u *url.URL) error {
 		err = fmt.Errorf("invalid websocket connection")
 	}
 	// Check compression extension...
+	var ctxTakeover bool
 	if err == nil && compress {
 		// Check that not only permessage-deflate extension is present, but that
-		// we also have server and client no context take over.
-		srvCompress, noCtxTakeover := wsPMCExtensionSupport(resp.Header)
+		// we also have server and client no context take over, unless we asked
+		// to keep the client context.
+		srvCompress, srvNoCtx, cliNoCtx := wsPMCExtensionSupport(resp.Header)
 
 		// If server does not support compression, then simply disable it in our side.
 		if !srvCompress {
 			compress = false
-		} else if !noCtxTakeover {
+		} else if !srvNoCtx || (!cliNoCtx && !nc.Opts.CompressionContextTakeover) {
 			err = fmt.Errorf("compression negotiation error")
+		} else {
+			ctxTakeover = !cliNoCtx
 		}
 	}
 	if resp != nil {
@@ -622,6 +705,7 @@ func (nc *Conn) wsInitHandshake(u *url.URL) error {
 	if err != nil {
 		return err
 	}
+	nc.wsExts = resp.Header.Values("Sec-WebSocket-Extensions")
 
 	wsr := wsNewReader(nc.br.r)
 	wsr.nc = nc
@@ -630,11 +714,31 @@ func (nc *Conn) wsInitHandshake(u *url.URL) error {
 		wsr.ib, _ = br.Peek(n)
 	}
 	nc.br.r = wsr
-	nc.bw.w = &websocketWriter{w: nc.bw.w, compress: compress}
+	nc.bw.w = &websocketWriter{
+		w:           nc.bw.w,
+		compress:    compress,
+		level:       nc.Opts.CompressionLevel,
+		ctxTakeover: ctxTakeover,
+		noMasking:   strings.EqualFold(resp.Header.Get(wsNoMaskingHeader), wsNoMaskingValue),
+		maxFrame:    nc.Opts.WebSocketMaxFrameSize,
+	}
 	nc.ws = true
+	nc.recordIO()
 	return nil
 }
 
+// WebSocketExtensions returns the websocket extensions negotiated with
+// the server, as sent in the Sec-WebSocket-Extensions headers of the
+// upgrade response, or nil if not connected with websocket.
+func (nc *Conn) WebSocketExtensions() []string {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+	if !nc.ws {
+		return nil
+	}
+	return append([]string(nil), nc.wsExts...)
+}
+
 func (nc *Conn) wsClose() {
 	nc.mu.Lock()
 	defer nc.mu.Unlock()
@@ -661,7 +765,7 @@ func (nc *Conn) wsEnqueueCloseMsgLocked(status int, payload string) {
 	}
 	statusAndPayloadLen := 2 + len(payload)
 	frame := make([]byte, 2+4+statusAndPayloadLen)
-	n, key := wsFillFrameHeader(frame, false, wsCloseMessage, statusAndPayloadLen)
+	n, key := wsFillFrameHeader(frame, !wr.noMasking, false, wsCloseMessage, statusAndPayloadLen)
 	// Set the status
 	binary.BigEndian.PutUint16(frame[n:], uint16(status))
 	// If there is a payload, copy
@@ -669,8 +773,10 @@ func (nc *Conn) wsEnqueueCloseMsgLocked(status int, payload string) {
 		copy(frame[n+2:], payload)
 	}
 	// Mask status + payload
-	wsMaskBuf(key, frame[n:n+statusAndPayloadLen])
-	wr.cm = frame
+	if key != nil {
+		wsMaskBuf(key, frame[n:n+statusAndPayloadLen])
+	}
+	wr.cm = frame[:n+statusAndPayloadLen]
 	wr.cmDone = true
 	nc.bw.flush()
 }
@@ -680,23 +786,28 @@ func (nc *Conn) wsEnqueueControlMsg(frameType wsOpCode, payload []byte) {
 	if nc == nil {
 		return
 	}
-	fh, key := wsCreateFrameHeader(false, frameType, len(payload))
 	nc.mu.Lock()
 	wr, ok := nc.bw.w.(*websocketWriter)
 	if !ok {
 		nc.mu.Unlock()
 		return
 	}
+	fh, key := wsCreateFrameHeader(!wr.noMasking, false, frameType, len(payload))
 	wr.ctrlFrames = append(wr.ctrlFrames, fh)
 	if len(payload) > 0 {
-		wsMaskBuf(key, payload)
+		if key != nil {
+			wsMaskBuf(key, payload)
+		}
 		wr.ctrlFrames = append(wr.ctrlFrames, payload)
 	}
 	nc.bw.flush()
 	nc.mu.Unlock()
 }
 
-func wsPMCExtensionSupport(header http.Header) (bool, bool) {
+// wsPMCExtensionSupport returns whether the per-message compression
+// extension is present, and with the server and client no context
+// takeover parameters.
+func wsPMCExtensionSupport(header http.Header) (bool, bool, bool) {
 	for _, extensionList := range header["Sec-Websocket-Extensions"] {
 		extensions := strings.Split(extensionList, ",")
 		for _, extension := range extensions {
@@ -715,16 +826,13 @@ func wsPMCExtensionSupport(header http.Header) (bool, bool) {
 						} else if strings.EqualFold(p, wsPMCCliNoCtx) {
 							cnc = true
 						}
-						if snc && cnc {
-							return true, true
-						}
 					}
-					return true, false
+					return true, snc, cnc
 				}
 			}
 		}
 	}
-	return false, false
+	return false, false, false
 }
 
 func wsMakeChallengeKey() (string, error) {
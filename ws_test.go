@@ -14,13 +14,17 @@
 package nats
 
 import (
+	"bufio"
 	"bytes"
 	"compress/flate"
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/http"
 	"reflect"
 	"strings"
 	"sync"
@@ -797,6 +801,70 @@ func TestWSCompressionWithContinuationFrames(t *testing.T) {
 	}
 }
 
+func TestWSWriterFrames(t *testing.T) {
+	payload := []byte(strings.Repeat("0123456789", 10))
+
+	// Unmasked frames of at most 30 bytes.
+	buf := &bytes.Buffer{}
+	w := &websocketWriter{w: buf, noMasking: true, maxFrame: 30}
+	if n, err := w.Write(payload); n != len(payload)+4*2 || err != nil {
+		t.Fatalf("Error writing: n=%v err=%v", n, err)
+	}
+	frames := buf.Bytes()
+	for i := 0; len(frames) > 0; i++ {
+		fh := frames[:2]
+		if fh[1]&wsMaskBit != 0 {
+			t.Fatalf("Frame %v should not be masked", i)
+		}
+		op := wsOpCode(fh[0] & 0xF)
+		if (i == 0 && op != wsBinaryMessage) || (i > 0 && op != wsContinuationFrame) {
+			t.Fatalf("Unexpected opcode %v for frame %v", op, i)
+		}
+		if final := fh[0]&wsFinalBit != 0; final != (i == 3) {
+			t.Fatalf("Unexpected final bit for frame %v", i)
+		}
+		frames = frames[2+int(fh[1]):]
+	}
+	r := wsNewReader(bytes.NewReader(buf.Bytes()))
+	rbuf, err := ioutil.ReadAll(r)
+	if err != io.EOF && err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if !bytes.Equal(rbuf, payload) {
+		t.Fatalf("Unexpected payload: %q", rbuf)
+	}
+
+	// Keeping the compression context makes a repeated message smaller.
+	buf.Reset()
+	w = &websocketWriter{w: buf, compress: true, ctxTakeover: true, level: flate.BestCompression, noMasking: true}
+	w.Write(payload)
+	first := buf.Len()
+	w.Write(payload)
+	if second := buf.Len() - first; second >= first {
+		t.Fatalf("Expected second message to be smaller than %v, got %v", first, second)
+	}
+	// Both messages can be decompressed with the same context.
+	var compressed []byte
+	frames = buf.Bytes()
+	for len(frames) > 0 {
+		if frames[0]&wsRsv1Bit == 0 {
+			t.Fatal("Expected compressed frame")
+		}
+		l := int(frames[1])
+		compressed = append(compressed, frames[2:2+l]...)
+		compressed = append(compressed, 0, 0, 0xff, 0xff)
+		frames = frames[2+l:]
+	}
+	dec := flate.NewReader(bytes.NewReader(compressed))
+	rbuf = make([]byte, 2*len(payload))
+	if _, err := io.ReadFull(dec, rbuf); err != nil {
+		t.Fatalf("Error decompressing: %v", err)
+	}
+	if !bytes.Equal(rbuf, append(payload, payload...)) {
+		t.Fatalf("Unexpected payload: %q", rbuf)
+	}
+}
+
 func TestWSWithTLS(t *testing.T) {
 	for _, test := range []struct {
 		name        string
@@ -1057,3 +1125,167 @@ func TestWSStress(t *testing.T) {
 		})
 	}
 }
+
+func TestWSHandshakeHeaders(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on an ephemeral port: %v", err)
+	}
+	defer l.Close()
+
+	// Fake websocket server reporting the upgrade requests.
+	reqs := make(chan *http.Request, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				reqs <- req
+				fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+					"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+					"Sec-WebSocket-Accept: %s\r\n"+
+					"Sec-WebSocket-Extensions: %s\r\nX-Gateway: test\r\n\r\n",
+					wsAcceptKey(req.Header.Get("Sec-WebSocket-Key")), wsPMCReqHeaderValue)
+				writeFrame := func(p string) {
+					conn.Write(append([]byte{wsFinalBit | byte(wsBinaryMessage), byte(len(p))}, p...))
+				}
+				writeFrame("INFO {\"server_id\":\"ws\"}\r\n")
+				// Answer the CONNECT and PING frame.
+				buf := make([]byte, 1024)
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				writeFrame("PONG\r\n")
+				io.Copy(io.Discard, conn)
+			}(conn)
+		}
+	}()
+
+	var gateway string
+	url := fmt.Sprintf("ws://%s", l.Addr())
+	nc, err := Connect(url,
+		Compression(true),
+		WebSocketHeaders(http.Header{"Origin": {"https://example.com"}, "Upgrade": {"nope"}}),
+		WebSocketRequestHandler(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer token")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			return nil
+		}),
+		WebSocketResponseHandler(func(resp *http.Response) error {
+			gateway = resp.Header.Get("X-Gateway")
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	req := <-reqs
+	for k, v := range map[string]string{
+		"Origin":        "https://example.com",
+		"Authorization": "Bearer token",
+		"Cookie":        "session=abc",
+		"Upgrade":       "websocket",
+	} {
+		if got := req.Header.Get(k); got != v {
+			t.Fatalf("Expected header %q to be %q, got %q", k, v, got)
+		}
+	}
+	if gateway != "test" {
+		t.Fatalf("Response handler did not get the response headers")
+	}
+	if exts := nc.WebSocketExtensions(); !reflect.DeepEqual(exts, []string{wsPMCReqHeaderValue}) {
+		t.Fatalf("Unexpected extensions: %q", exts)
+	}
+
+	// The handlers can fail the connection attempt.
+	errRejected := fmt.Errorf("rejected")
+	_, err = Connect(url, WebSocketResponseHandler(func(*http.Response) error { return errRejected }))
+	if err != errRejected {
+		t.Fatalf("Expected %v, got %v", errRejected, err)
+	}
+}
+
+func TestWSRecordProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on an ephemeral port: %v", err)
+	}
+	defer l.Close()
+
+	// Fake websocket server answering the CONNECT and PING frame.
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n",
+			wsAcceptKey(req.Header.Get("Sec-WebSocket-Key")))
+		writeFrame := func(p string) {
+			conn.Write(append([]byte{wsFinalBit | byte(wsBinaryMessage), byte(len(p))}, p...))
+		}
+		writeFrame("INFO {\"server_id\":\"ws\"}\r\n")
+		if _, err := br.Read(make([]byte, 1024)); err != nil {
+			return
+		}
+		writeFrame("PONG\r\n")
+		io.Copy(io.Discard, br)
+	}()
+
+	var capture bytes.Buffer
+	rec := NewProtocolRecorder(&capture, false)
+	nc, err := Connect(fmt.Sprintf("ws://%s", l.Addr()), RecordProtocol(rec))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	nc.Close()
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+
+	// Only the protocol is recorded, not the handshake or the frames.
+	var connects int
+	var sent, received []byte
+	cr := NewCaptureReader(&capture)
+	for {
+		r, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading capture: %v", err)
+		}
+		switch r.Dir {
+		case CaptureConnect:
+			connects++
+		case CaptureSent:
+			sent = append(sent, r.Data...)
+		case CaptureReceived:
+			received = append(received, r.Data...)
+		}
+	}
+	if connects != 1 {
+		t.Fatalf("Expected 1 connection, got %v", connects)
+	}
+	if expected := "INFO {\"server_id\":\"ws\"}\r\nPONG\r\n"; string(received) != expected {
+		t.Fatalf("Expected received %q, got %q", expected, received)
+	}
+	if !bytes.HasPrefix(sent, []byte("CONNECT {")) || !bytes.HasSuffix(sent, []byte("PING\r\n")) {
+		t.Fatalf("Unexpected sent data: %q", sent)
+	}
+}